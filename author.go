@@ -101,3 +101,157 @@ func (as Authors) String() string {
 	}
 	return sb.String()
 }
+
+// the kinds of the fixes made by FixAuthors
+const (
+	AuthorFixOthers   = "others"   // Smith, J. et al. -> Smith, J. and others
+	AuthorFixSpacing  = "spacing"  // J.R.R. Tolkien -> J. R. R. Tolkien
+	AuthorFixReversed = "reversed" // J., Smith -> Smith, J.
+	AuthorFixDots     = "dots"     // J Smith -> J. Smith
+)
+
+var (
+	// initialRE matches a token of an initial with or without the dot, e.g., J, J., or J.-P.
+	initialRE = regexp.MustCompile(`\A[A-ZÀ-Ú]\.?(-[A-ZÀ-Ú]\.?)*\z`)
+	// packedInitialsRE matches an initial followed by another letter without a space
+	packedInitialsRE = regexp.MustCompile(`([A-ZÀ-Ú]\.)([A-ZÀ-Ú])`)
+	// othersRE matches a name standing for the rest of the authors
+	othersRE = regexp.MustCompile(`(?i)\A(others|et\.?\s*al\.?)\z`)
+	// etAlRE matches et al. at the end of a name
+	etAlRE = regexp.MustCompile(`(?i)\s+et\.?\s*al\.?\z`)
+)
+
+// AuthorFix is a name changed by FixAuthors
+type AuthorFix struct {
+	Kind   string // one of the AuthorFix kinds
+	Before string
+	After  string
+}
+
+// FixAuthors fixes the common problems of the names in an author field rejected by
+// NewAuthors, and returns the fixed value with the fixes made in order
+func FixAuthors(authorFieldValue string) (string, []AuthorFix) {
+	var fixes []AuthorFix
+	var names []string
+	hasOthers := false
+	for _, name := range strings.Split(authorFieldValue, " and ") {
+		name = strings.TrimSpace(name)
+		switch {
+		case othersRE.MatchString(name):
+			if name != "others" {
+				fixes = append(fixes, AuthorFix{Kind: AuthorFixOthers, Before: name, After: "others"})
+			}
+			hasOthers = true
+			continue
+		case etAlRE.MatchString(name):
+			fixed := etAlRE.ReplaceAllString(name, "")
+			fixes = append(fixes, AuthorFix{Kind: AuthorFixOthers, Before: name, After: fixed + " and others"})
+			name = fixed
+			hasOthers = true
+		}
+		fixed, nameFixes := fixName(name)
+		fixes = append(fixes, nameFixes...)
+		names = append(names, fixed)
+	}
+	if len(fixes) == 0 {
+		return authorFieldValue, nil
+	}
+	if hasOthers {
+		names = append(names, "others")
+	}
+	return strings.Join(names, " and "), fixes
+}
+
+// fixName fixes the spacing and the dots of the initials in a name, and swaps the first
+// and last names if the last name is only initials
+func fixName(name string) (string, []AuthorFix) {
+	var fixes []AuthorFix
+	// the names in braces are kept as they are, e.g., {World Wide Web Consortium}
+	if strings.HasPrefix(name, "{") {
+		return name, nil
+	}
+	fix := func(kind, fixed string) {
+		if fixed != name {
+			fixes = append(fixes, AuthorFix{Kind: kind, Before: name, After: fixed})
+			name = fixed
+		}
+	}
+
+	spaced := name
+	for packedInitialsRE.MatchString(spaced) {
+		spaced = packedInitialsRE.ReplaceAllString(spaced, "$1 $2")
+	}
+	fix(AuthorFixSpacing, spaced)
+
+	var first, last []string
+	switch parts := strings.Split(name, ","); len(parts) {
+	case 1:
+		tokens := strings.Fields(parts[0])
+		// the initials after the last name, e.g., Smith J
+		split := len(tokens)
+		for split > 0 && initialRE.MatchString(tokens[split-1]) {
+			split--
+		}
+		if split > 0 && split < len(tokens) && !anyInitial(tokens[:split]) {
+			first, last = tokens[split:], tokens[:split]
+			fix(AuthorFixReversed, strings.Join(last, " ")+", "+strings.Join(first, " "))
+		} else if len(tokens) > 1 {
+			first, last = tokens[:len(tokens)-1], tokens[len(tokens)-1:]
+		}
+	case 2:
+		last, first = strings.Fields(parts[0]), strings.Fields(parts[1])
+		// the initials before the comma, e.g., J., Smith
+		if len(last) > 0 && len(first) > 0 && allInitials(last) && !anyInitial(first) {
+			first, last = last, first
+			fix(AuthorFixReversed, strings.Join(last, " ")+", "+strings.Join(first, " "))
+		}
+	default:
+		return name, fixes
+	}
+	if len(first) == 0 || !dotInitials(first) {
+		return name, fixes
+	}
+	if strings.Contains(name, ",") {
+		fix(AuthorFixDots, strings.Join(last, " ")+", "+strings.Join(first, " "))
+	} else {
+		fix(AuthorFixDots, strings.Join(first, " ")+" "+strings.Join(last, " "))
+	}
+	return name, fixes
+}
+
+// dotInitials adds the missing dots to the initials in place, and reports whether any is added
+func dotInitials(tokens []string) bool {
+	dotted := false
+	for i, token := range tokens {
+		if !initialRE.MatchString(token) {
+			continue
+		}
+		letters := strings.Split(token, "-")
+		for j, letter := range letters {
+			if !strings.HasSuffix(letter, ".") {
+				letters[j] = letter + "."
+				dotted = true
+			}
+		}
+		tokens[i] = strings.Join(letters, "-")
+	}
+	return dotted
+}
+
+func allInitials(tokens []string) bool {
+	for _, token := range tokens {
+		if !initialRE.MatchString(token) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyInitial(tokens []string) bool {
+	for _, token := range tokens {
+		if initialRE.MatchString(token) {
+			return true
+		}
+	}
+	return false
+}
@@ -183,3 +183,79 @@ func TestAuthors(t *testing.T) {
 		}
 	}
 }
+
+var fixauthorstests = []struct {
+	in    string
+	out   string
+	kinds []string
+}{
+	{
+		"Mizutani, Iori and Jane Roe and others",
+		"Mizutani, Iori and Jane Roe and others",
+		nil,
+	},
+	{
+		"J Smith",
+		"J. Smith",
+		[]string{AuthorFixDots},
+	},
+	{
+		"Smith, J R and J-P Sartre",
+		"Smith, J. R. and J.-P. Sartre",
+		[]string{AuthorFixDots, AuthorFixDots},
+	},
+	{
+		"J.R.R. Tolkien and Tolkien, J.R.R.",
+		"J. R. R. Tolkien and Tolkien, J. R. R.",
+		[]string{AuthorFixSpacing, AuthorFixSpacing},
+	},
+	{
+		"J., Smith",
+		"Smith, J.",
+		[]string{AuthorFixReversed},
+	},
+	{
+		"Smith J and van der Berg J. R.",
+		"Smith, J. and van der Berg, J. R.",
+		[]string{AuthorFixReversed, AuthorFixDots, AuthorFixReversed},
+	},
+	{
+		"Smith, J. et al.",
+		"Smith, J. and others",
+		[]string{AuthorFixOthers},
+	},
+	{
+		"Mizutani, Iori and et al",
+		"Mizutani, Iori and others",
+		[]string{AuthorFixOthers},
+	},
+	{
+		"J Smith and Others",
+		"J. Smith and others",
+		[]string{AuthorFixDots, AuthorFixOthers},
+	},
+	{
+		"{World Wide Web Consortium} and Mizutani, Iori, Dr.",
+		"{World Wide Web Consortium} and Mizutani, Iori, Dr.",
+		nil,
+	},
+}
+
+func TestFixAuthors(t *testing.T) {
+	for _, tt := range fixauthorstests {
+		out, fixes := FixAuthors(tt.in)
+		if out != tt.out {
+			t.Errorf("FixAuthors(%v) => %v, want %v", tt.in, out, tt.out)
+		}
+		var kinds []string
+		for _, fix := range fixes {
+			kinds = append(kinds, fix.Kind)
+		}
+		if !reflect.DeepEqual(kinds, tt.kinds) {
+			t.Errorf("FixAuthors(%v) kinds => %v, want %v", tt.in, kinds, tt.kinds)
+		}
+		if _, err := NewAuthors(out); err != nil && tt.kinds != nil {
+			t.Errorf("NewAuthors(%v) err => %v, want nil", out, err)
+		}
+	}
+}
@@ -23,23 +23,31 @@ type BibItem struct {
 	CiteType       string `default:"" bibtex:"cite_type"`
 	Title          string `default:"" bibtex:"title"`
 	Author         string `default:"" bibtex:"author"`
+	Address        string `default:"" bibtex:"address"`
 	Booktitle      string `default:"" bibtex:"booktitle"`
+	Chapter        string `default:"" bibtex:"chapter"`
 	DOI            string `default:"" bibtex:"doi"`
 	Edition        string `default:"" bibtex:"edition"`
+	Editor         string `default:"" bibtex:"editor"`
+	Eprint         string `default:"" bibtex:"eprint"`
+	Howpublished   string `default:"" bibtex:"howpublished"`
 	ISBN           string `default:"" bibtex:"isbn"`
 	ISSN           string `default:"" bibtex:"issn"`
 	Institution    string `default:"" bibtex:"institution"`
 	Journal        string `default:"" bibtex:"journal"`
 	Metanote       string `default:"" bibtex:"metanote"`
+	Month          string `default:"" bibtex:"month"`
 	Note           string `default:"" bibtex:"note"`
 	Number         string `default:"" bibtex:"number"`
 	Numpages       string `default:"" bibtex:"numpages"`
+	Organization   string `default:"" bibtex:"organization"`
 	Pages          string `default:"" bibtex:"pages"`
 	Publisher      string `default:"" bibtex:"publisher"`
 	School         string `default:"" bibtex:"school"`
 	Series         string `default:"" bibtex:"series"`
 	TechreportType string `default:"" bibtex:"type"`
 	URL            string `default:"" bibtex:"url"`
+	URLDate        string `default:"" bibtex:"urldate"`
 	Version        string `default:"" bibtex:"version"`
 	Volume         string `default:"" bibtex:"volume"`
 	Year           string `default:"" bibtex:"year"`
@@ -148,6 +156,20 @@ func (fs Filters) filterFor(citeType string) Filter {
 	return Filter{}
 }
 
+// BuildError is an entry which failed to build, or a problem found in building it
+type BuildError struct {
+	CiteName string
+	Err      error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("[%v] %v", e.CiteName, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
 // BuildBibItem returns BibItem with the filter
 func (fs Filters) BuildBibItem(entry *bibtex.BibEntry, smart bool, oneofs Oneofs) (BibItem, error) {
 	bi := NewBibItem()
@@ -156,10 +178,10 @@ func (fs Filters) BuildBibItem(entry *bibtex.BibEntry, smart bool, oneofs Oneofs
 
 	for k, v := range entry.Fields {
 		switch k {
-		case "author":
+		case "author", "editor":
 			authors, err := NewAuthors(v.String())
 			if err != nil {
-				return bi, fmt.Errorf("[%v] %w", bi.CiteName, err)
+				return bi, &BuildError{CiteName: bi.CiteName, Err: err}
 			}
 			_ = bi.SetFieldByBibTexName(k, authors.String())
 		default:
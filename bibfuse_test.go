@@ -19,9 +19,9 @@ var itemtests = []struct {
 	out map[string]string
 }{
 	{
-		BibItem{"mizutani2021article", "article", "{Title of the Article}", "Mizutani, Iori", "", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "", "(TODO)", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "(OPTIONAL)", "(OPTIONAL)", "", "", "", "(OPTIONAL)", "", "(OPTIONAL)", "2021"},
+		BibItem{"mizutani2021article", "article", "{Title of the Article}", "Mizutani, Iori", "", "", "", "(OPTIONAL)", "", "", "", "", "(OPTIONAL)", "(OPTIONAL)", "", "(TODO)", "(OPTIONAL)", "", "", "(OPTIONAL)", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "", "", "", "(OPTIONAL)", "", "", "(OPTIONAL)", "2021"},
 		ByBibTexName,
-		map[string]string{"author": "Mizutani, Iori", "booktitle": "", "cite_name": "mizutani2021article", "cite_type": "article", "doi": "(OPTIONAL)", "edition": "", "institution": "", "isbn": "(OPTIONAL)", "issn": "(OPTIONAL)", "journal": "(TODO)", "metanote": "(OPTIONAL)", "note": "", "number": "(OPTIONAL)", "numpages": "(OPTIONAL)", "pages": "(OPTIONAL)", "publisher": "(OPTIONAL)", "school": "", "series": "", "title": "{Title of the Article}", "type": "", "url": "(OPTIONAL)", "version": "", "volume": "(OPTIONAL)", "year": "2021"},
+		map[string]string{"address": "", "author": "Mizutani, Iori", "booktitle": "", "chapter": "", "cite_name": "mizutani2021article", "cite_type": "article", "doi": "(OPTIONAL)", "edition": "", "editor": "", "eprint": "", "howpublished": "", "institution": "", "isbn": "(OPTIONAL)", "issn": "(OPTIONAL)", "journal": "(TODO)", "metanote": "(OPTIONAL)", "month": "", "note": "", "number": "(OPTIONAL)", "numpages": "(OPTIONAL)", "organization": "", "pages": "(OPTIONAL)", "publisher": "(OPTIONAL)", "school": "", "series": "", "title": "{Title of the Article}", "type": "", "url": "(OPTIONAL)", "urldate": "", "version": "", "volume": "(OPTIONAL)", "year": "2021"},
 	},
 }
 
@@ -47,28 +47,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@article{mizutani2021article,
-    title       = {{Title of the Article}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "(OPTIONAL)",
-    edition     = "",
-    institution = "",
-    isbn        = "(OPTIONAL)",
-    issn        = "(OPTIONAL)",
-    journal     = "(TODO)",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "(OPTIONAL)",
-    numpages    = "(OPTIONAL)",
-    pages       = "(OPTIONAL)",
-    publisher   = "(OPTIONAL)",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "(OPTIONAL)",
-    year        = "(TODO)",
+    title        = {{Title of the Article}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "(OPTIONAL)",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "(OPTIONAL)",
+    journal      = "(TODO)",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "(OPTIONAL)",
+    numpages     = "(OPTIONAL)",
+    organization = "",
+    pages        = "(OPTIONAL)",
+    publisher    = "(OPTIONAL)",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "(OPTIONAL)",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -77,28 +85,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@book{mizutani2021book,
-    title       = {{Title of the Book}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "(OPTIONAL)",
-    edition     = "(OPTIONAL)",
-    institution = "",
-    isbn        = "(OPTIONAL)",
-    issn        = "(OPTIONAL)",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "(TODO)",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Book}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "(OPTIONAL)",
+    edition      = "(OPTIONAL)",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "(OPTIONAL)",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "(TODO)",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -107,28 +123,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@incollection{mizutani2021incollection,
-    title       = {{Title of the Book Chapter}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "(TODO)",
-    doi         = "(OPTIONAL)",
-    edition     = "",
-    institution = "",
-    isbn        = "(OPTIONAL)",
-    issn        = "(OPTIONAL)",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "(OPTIONAL)",
-    pages       = "(OPTIONAL)",
-    publisher   = "(TODO)",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Book Chapter}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "(TODO)",
+    chapter      = "",
+    doi          = "(OPTIONAL)",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "(OPTIONAL)",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "(OPTIONAL)",
+    organization = "",
+    pages        = "(OPTIONAL)",
+    publisher    = "(TODO)",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -137,28 +161,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@inproceedings{mizutani2021inproceedings,
-    title       = {{Title of the Conference Paper}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "(TODO)",
-    doi         = "(OPTIONAL)",
-    edition     = "",
-    institution = "",
-    isbn        = "(OPTIONAL)",
-    issn        = "(OPTIONAL)",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "(OPTIONAL)",
-    pages       = "(OPTIONAL)",
-    publisher   = "(OPTIONAL)",
-    school      = "",
-    series      = "(OPTIONAL)",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Conference Paper}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "(TODO)",
+    chapter      = "",
+    doi          = "(OPTIONAL)",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "(OPTIONAL)",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "(OPTIONAL)",
+    organization = "",
+    pages        = "(OPTIONAL)",
+    publisher    = "(OPTIONAL)",
+    school       = "",
+    series       = "(OPTIONAL)",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -167,28 +199,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@mastersthesis{mizutani2021mastersthesis,
-    title       = {{Title of the Master's Thesis}},
-    author      = "(TODO)",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "",
-    edition     = "",
-    institution = "",
-    isbn        = "",
-    issn        = "",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "(TODO)",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Master's Thesis}},
+    author       = "(TODO)",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "(TODO)",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -197,28 +237,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@misc{mizutani2021misc,
-    title       = {{Title of the Resource}},
-    author      = "Mizutani, Iori",
-    url         = "(TODO)",
-    booktitle   = "",
-    doi         = "",
-    edition     = "",
-    institution = "(OPTIONAL)",
-    isbn        = "",
-    issn        = "",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "(TODO)",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Resource}},
+    author       = "Mizutani, Iori",
+    url          = "(TODO)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "(OPTIONAL)",
+    isbn         = "",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "(TODO)",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -227,28 +275,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@phdthesis{mizutani2021phdthesis,
-    title       = {{Title of the Ph.D. Thesis}},
-    author      = "(TODO)",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "",
-    edition     = "",
-    institution = "",
-    isbn        = "",
-    issn        = "",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "(TODO)",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Ph.D. Thesis}},
+    author       = "(TODO)",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "(TODO)",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -257,28 +313,36 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@techreport{mizutani2021techreport,
-    title       = {{Title of the Technical Document}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "",
-    edition     = "",
-    institution = "(TODO)",
-    isbn        = "",
-    issn        = "",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "",
-    series      = "(OPTIONAL)",
-    type        = "",
-    version     = "(OPTIONAL)",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Technical Document}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "(TODO)",
+    isbn         = "",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "",
+    series       = "(OPTIONAL)",
+    type         = "",
+    urldate      = "",
+    version      = "(OPTIONAL)",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -287,28 +351,74 @@ var bibtests = []struct {
 		Oneofs{},
 		nil,
 		`@unpublished{mizutani2021unpublished,
-    title       = {{Title of the Unpublished Work}},
-    author      = "Mizutani, Iori",
-    url         = "(TODO)",
-    booktitle   = "",
-    doi         = "",
-    edition     = "",
-    institution = "",
-    isbn        = "",
-    issn        = "",
-    journal     = "",
-    metanote    = "(OPTIONAL)",
-    note        = "(TODO)",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "",
+    title        = {{Title of the Unpublished Work}},
+    author       = "Mizutani, Iori",
+    url          = "(TODO)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "(TODO)",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "",
+}
+`,
+	}, {
+		"@inbook{mizutani2021inbook,\ntitle={{Title of the Book}},\nauthor=\"Mizutani, Iori\",\neditor=\"Iori Mizutani and Jane Roe\",\nchapter=3,\n}",
+		false,
+		Oneofs{},
+		nil,
+		`@inbook{mizutani2021inbook,
+    title        = {{Title of the Book}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "(OPTIONAL)",
+    booktitle    = "",
+    chapter      = 3,
+    doi          = "(OPTIONAL)",
+    edition      = "(OPTIONAL)",
+    editor       = "Iori Mizutani and Jane Roe",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "",
+    journal      = "",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "(OPTIONAL)",
+    publisher    = "(TODO)",
+    school       = "",
+    series       = "(OPTIONAL)",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -325,28 +435,36 @@ var bibtests = []struct {
 		}},
 		nil,
 		`@article{mizutani2021article,
-    title       = {{Title of the Article}},
-    author      = "Mizutani, Iori",
-    url         = "",
-    booktitle   = "",
-    doi         = "xxxxx/xxxxx.xxx.xx",
-    edition     = "",
-    institution = "",
-    isbn        = "",
-    issn        = "",
-    journal     = "(TODO)",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "",
-    numpages    = "",
-    pages       = "",
-    publisher   = "",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "",
-    year        = "(TODO)",
+    title        = {{Title of the Article}},
+    author       = "Mizutani, Iori",
+    url          = "",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "xxxxx/xxxxx.xxx.xx",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "",
+    issn         = "",
+    journal      = "(TODO)",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "",
+    numpages     = "",
+    organization = "",
+    pages        = "",
+    publisher    = "",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "",
+    year         = "(TODO)",
 }
 `,
 	}, {
@@ -403,7 +521,7 @@ var fieldtests = []struct {
 	{
 		NewBibItem(),
 		map[string]string{"name": "title", "value": "Title"},
-		BibItem{"", "", "Title", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", ""},
+		BibItem{"", "", "Title", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", ""},
 		nil,
 	},
 }
@@ -424,30 +542,38 @@ var bibitemtests = []struct {
 	out string
 }{
 	{
-		BibItem{"mizutani2021article", "article", "{Title of the Article}", "Mizutani, Iori", "", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "", "(TODO)", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "(OPTIONAL)", "(OPTIONAL)", "", "", "", "(OPTIONAL)", "", "(OPTIONAL)", "2021"},
+		BibItem{"mizutani2021article", "article", "{Title of the Article}", "Mizutani, Iori", "", "", "", "(OPTIONAL)", "", "", "", "", "(OPTIONAL)", "(OPTIONAL)", "", "(TODO)", "(OPTIONAL)", "", "", "(OPTIONAL)", "(OPTIONAL)", "", "(OPTIONAL)", "(OPTIONAL)", "", "", "", "(OPTIONAL)", "", "", "(OPTIONAL)", "2021"},
 		`@article{mizutani2021article,
-    title       = {{Title of the Article}},
-    author      = "Mizutani, Iori",
-    url         = "(OPTIONAL)",
-    booktitle   = "",
-    doi         = "(OPTIONAL)",
-    edition     = "",
-    institution = "",
-    isbn        = "(OPTIONAL)",
-    issn        = "(OPTIONAL)",
-    journal     = "(TODO)",
-    metanote    = "(OPTIONAL)",
-    note        = "",
-    number      = "(OPTIONAL)",
-    numpages    = "(OPTIONAL)",
-    pages       = "(OPTIONAL)",
-    publisher   = "(OPTIONAL)",
-    school      = "",
-    series      = "",
-    type        = "",
-    version     = "",
-    volume      = "(OPTIONAL)",
-    year        = 2021,
+    title        = {{Title of the Article}},
+    author       = "Mizutani, Iori",
+    url          = "(OPTIONAL)",
+    address      = "",
+    booktitle    = "",
+    chapter      = "",
+    doi          = "(OPTIONAL)",
+    edition      = "",
+    editor       = "",
+    eprint       = "",
+    howpublished = "",
+    institution  = "",
+    isbn         = "(OPTIONAL)",
+    issn         = "(OPTIONAL)",
+    journal      = "(TODO)",
+    metanote     = "(OPTIONAL)",
+    month        = "",
+    note         = "",
+    number       = "(OPTIONAL)",
+    numpages     = "(OPTIONAL)",
+    organization = "",
+    pages        = "(OPTIONAL)",
+    publisher    = "(OPTIONAL)",
+    school       = "",
+    series       = "",
+    type         = "",
+    urldate      = "",
+    version      = "",
+    volume       = "(OPTIONAL)",
+    year         = 2021,
 }
 `,
 	},
@@ -563,3 +689,18 @@ func loadConfig(t *testing.T) Filters {
 
 	return filters
 }
+
+func TestBuildError(t *testing.T) {
+	parsed, err := bibtex.Parse(strings.NewReader("@article{a,\ntitle={A},\nauthor={Smith, J},\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Filters{}.BuildBibItem(parsed.Entries[0], false, Oneofs{})
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) || buildErr.CiteName != "a" {
+		t.Fatalf("BuildBibItem() err => %v, want a BuildError of a", err)
+	}
+	if got, want := err.Error(), "[a] "+buildErr.Err.Error(); got != want {
+		t.Errorf("BuildError.Error() => %v, want %v", got, want)
+	}
+}
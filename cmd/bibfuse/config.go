@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+	"github.com/spf13/viper"
+)
+
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	conf := fs.String("config", defaultConfigFile, "The bibfuse.[toml|yml] defining the filters.")
+	force := fs.Bool("force", false, "Overwrite the existing config with init.")
+	var format outputFormat
+	bindReportFlags(fs, &format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s config: [options] show|init|check\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  show\n        Print the effective rules after resolving extends and inherits.")
+		fmt.Fprintln(os.Stderr, "  init\n        Write the built-in rules to the config file for customization.")
+		fmt.Fprintln(os.Stderr, "  check\n        Validate the config and report the problems found.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{
+		config:           *conf,
+		useDefaultConfig: *conf == defaultConfigFile,
+	}
+
+	switch fs.Arg(0) {
+	case "init":
+		if err := initConfig(opts.config, *force); err != nil {
+			return err
+		}
+		if format.isJSON() {
+			return printJSON(map[string]string{"config": opts.config})
+		}
+	case "check":
+		if err := configureViper(opts); err != nil {
+			return err
+		}
+		config, err := readConfig()
+		if err != nil {
+			return err
+		}
+		errs := config.Validate()
+		if format.isJSON() {
+			report := checkReport{Sources: config.Sources, Problems: []configProblem{}}
+			for _, e := range errs {
+				report.Problems = append(report.Problems, configProblem{e.File, e.Key, e.Msg})
+			}
+			if err := printJSON(report); err != nil {
+				return err
+			}
+		} else {
+			for _, e := range errs {
+				fmt.Println(e)
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("config: %d problem(s) found", len(errs))
+		}
+		log.Printf("config: no problems found in %s", strings.Join(config.Sources, ", "))
+	case "show":
+		if err := configureViper(opts); err != nil {
+			return err
+		}
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if format.isJSON() {
+			report := configReport{Sources: config.Sources, Types: make(map[string]map[string][]string, len(config.Types))}
+			for citeType, tc := range config.Types {
+				report.Types[citeType] = tc.Rules
+			}
+			return printJSON(report)
+		}
+		for _, source := range config.Sources {
+			fmt.Printf("# %s\n", source)
+		}
+		fmt.Print(config.String())
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// configReport is the result of config show for -format json
+type configReport struct {
+	Sources []string                       `json:"sources"`
+	Types   map[string]map[string][]string `json:"types"` // the rules by the citation type
+}
+
+// checkReport is the result of config check for -format json
+type checkReport struct {
+	Sources  []string        `json:"sources"`
+	Problems []configProblem `json:"problems"`
+}
+
+// configProblem is a problem found in the config
+type configProblem struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+	Msg  string `json:"msg"`
+}
+
+func configureViper(opts options) error {
+	if !opts.useDefaultConfig {
+		configPath, err := filepath.Abs(opts.config)
+		if err != nil {
+			return err
+		}
+		viper.SetConfigFile(configPath)
+		return nil
+	}
+
+	viper.SetConfigName("bibfuse")
+	viper.AddConfigPath(".")
+	return nil
+}
+
+// readConfig reads the config with its extends chain, it falls back to
+// the built-in config if no bibfuse config is in the working directory
+func readConfig() (*bibfuse.Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		return bibfuse.DefaultConfig(), nil
+	}
+	return bibfuse.LoadConfig(viper.ConfigFileUsed())
+}
+
+// loadConfig reads and validates the config, then resolves the inherits
+func loadConfig() (*bibfuse.Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return config.Resolve()
+}
+
+// initConfig writes the built-in config to configPath
+func initConfig(configPath string, force bool) error {
+	if _, err := os.Stat(configPath); err == nil && !force {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", configPath)
+	}
+	if err := os.WriteFile(configPath, bibfuse.DefaultConfigTOML(), 0o644); err != nil {
+		return err
+	}
+	log.Printf("the built-in rules written to %s", configPath)
+	return nil
+}
+
+func loadRules() (bibfuse.Filters, bibfuse.Oneofs, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config.Filters(), config.Oneofs(), nil
+}
+
+// loadNormalizers checks the flags choosing the conversions, and reads the venue registry
+// and the title word list of opts, a missing default file means an empty one
+func loadNormalizers(opts *options) error {
+	switch opts.encoding {
+	case "", encodingUnicode, encodingLaTeX:
+	default:
+		return fmt.Errorf("-encoding %q: want %s or %s", opts.encoding, encodingUnicode, encodingLaTeX)
+	}
+	switch opts.venue {
+	case "", venueFull, venueAbbrev:
+	default:
+		return fmt.Errorf("-venue %q: want %s or %s", opts.venue, venueFull, venueAbbrev)
+	}
+	switch opts.titleCase {
+	case bibfuse.TitleCaseKeep, bibfuse.TitleCaseSentence, bibfuse.TitleCaseTitle:
+	default:
+		return fmt.Errorf("-title-case %q: want %s or %s", opts.titleCase, bibfuse.TitleCaseSentence, bibfuse.TitleCaseTitle)
+	}
+
+	if err := loadWriteOptions(opts); err != nil {
+		return err
+	}
+
+	venues, err := bibfuse.LoadVenues(opts.venuesFile)
+	if err == nil {
+		opts.venues = venues
+	} else if opts.venuesFile != defaultVenuesFile || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	titleWords, err := bibfuse.LoadTitleWords(opts.titleWordsFile)
+	if err == nil {
+		opts.titleWords = titleWords
+	} else if opts.titleWordsFile != defaultTitleWordsFile || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// loadWriteOptions checks the style of the bibtex written, which is given only to the commands writing bibtex
+func loadWriteOptions(opts *options) error {
+	if opts.indent == "" {
+		return nil
+	}
+	switch opts.indent {
+	case "tab":
+		opts.writeOptions.Indent = "\t"
+	default:
+		n, err := strconv.Atoi(opts.indent)
+		if err != nil || n < 0 {
+			return fmt.Errorf("-indent %q: want the number of spaces or tab", opts.indent)
+		}
+		opts.writeOptions.Indent = strings.Repeat(" ", n)
+	}
+	if err := opts.writeOptions.Validate(); err != nil {
+		return fmt.Errorf("output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+	"github.com/nickng/bibtex"
+)
+
+const (
+	createTableSQL = `CREATE TABLE IF NOT EXISTS entries(
+            id INTEGER PRIMARY KEY,
+            cite_name TEXT UNIQUE NOT NULL,
+            cite_type TEXT NOT NULL,
+            author TEXT DEFAULT "",
+            title TEXT DEFAULT "",
+            booktitle TEXT DEFAULT "",
+            doi TEXT DEFAULT "",
+            edition TEXT DEFAULT "",
+            isbn TEXT DEFAULT "",
+            issn TEXT DEFAULT "",
+            institution TEXT DEFAULT "",
+            journal TEXT DEFAULT "",
+            metanote TEXT DEFAULT "",
+            note TEXT DEFAULT "",
+            number TEXT DEFAULT "",
+            numpages TEXT DEFAULT "",
+            pages TEXT DEFAULT "",
+            publisher TEXT DEFAULT "",
+            school TEXT DEFAULT "",
+            series TEXT DEFAULT "",
+            type TEXT DEFAULT "",
+            url TEXT DEFAULT "",
+            version TEXT DEFAULT "",
+            volume TEXT DEFAULT "",
+            year TEXT,
+            address TEXT DEFAULT "",
+            chapter TEXT DEFAULT "",
+            editor TEXT DEFAULT "",
+            eprint TEXT DEFAULT "",
+            howpublished TEXT DEFAULT "",
+            month TEXT DEFAULT "",
+            organization TEXT DEFAULT "",
+            urldate TEXT DEFAULT ""
+        );`
+	insertEntrySQL = `INSERT OR IGNORE INTO entries (
+            cite_name, cite_type, title, author, booktitle, doi, edition, isbn, issn,
+            institution, journal, metanote, note, number, numpages, pages, publisher,
+            school, series, url, type, version, volume, year,
+            address, chapter, editor, eprint, howpublished, month, organization, urldate
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	updateEntrySQL = `UPDATE entries SET
+            cite_type = ?, title = ?, author = ?, booktitle = ?, doi = ?, edition = ?, isbn = ?, issn = ?,
+            institution = ?, journal = ?, metanote = ?, note = ?, number = ?, numpages = ?, pages = ?, publisher = ?,
+            school = ?, series = ?, url = ?, type = ?, version = ?, volume = ?, year = ?,
+            address = ?, chapter = ?, editor = ?, eprint = ?, howpublished = ?, month = ?, organization = ?, urldate = ?
+        WHERE cite_name = ?`
+	createStringsTableSQL = `CREATE TABLE IF NOT EXISTS strings(
+            name TEXT PRIMARY KEY,
+            value TEXT NOT NULL,
+            file TEXT DEFAULT ""
+        );`
+	insertStringSQL = `INSERT OR IGNORE INTO strings (name, value, file) VALUES (?, ?, ?)`
+	upsertStringSQL = `INSERT INTO strings (name, value, file) VALUES (?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET value = excluded.value, file = excluded.file`
+	selectStringsSQL   = `SELECT name, value FROM strings`
+	createTagsTableSQL = `CREATE TABLE IF NOT EXISTS tags(
+            cite_name TEXT NOT NULL,
+            tag TEXT NOT NULL,
+            PRIMARY KEY (cite_name, tag)
+        );`
+	insertTagSQL  = `INSERT OR IGNORE INTO tags (cite_name, tag) VALUES (?, ?)`
+	deleteTagSQL  = `DELETE FROM tags WHERE cite_name = ? AND tag = ?`
+	selectTagsSQL = `SELECT cite_name, tag FROM tags ORDER BY cite_name, tag`
+	// the entry as of the last import or sync is the base to tell which side changed
+	createSourcesTableSQL = `CREATE TABLE IF NOT EXISTS sources(
+            cite_name TEXT PRIMARY KEY,
+            file TEXT NOT NULL,
+            line INTEGER NOT NULL,
+            synced TEXT NOT NULL
+        );`
+	insertSourceSQL = `INSERT OR IGNORE INTO sources (cite_name, file, line, synced) VALUES (?, ?, ?, ?)`
+	upsertSourceSQL = `INSERT INTO sources (cite_name, file, line, synced) VALUES (?, ?, ?, ?)
+        ON CONFLICT(cite_name) DO UPDATE SET file = excluded.file, line = excluded.line, synced = excluded.synced`
+	selectSourcesSQL = `SELECT cite_name, file, line, synced FROM sources`
+	// the blocks other than the entries and the string variables (i.e., @preamble, @comment,
+	// and the text between the blocks) as they are in the files
+	createBlocksTableSQL = `CREATE TABLE IF NOT EXISTS blocks(
+            id INTEGER PRIMARY KEY,
+            type TEXT NOT NULL,
+            text TEXT NOT NULL,
+            file TEXT NOT NULL,
+            line INTEGER NOT NULL,
+            UNIQUE (type, text, file)
+        );`
+	insertBlockSQL     = `INSERT OR IGNORE INTO blocks (type, text, file, line) VALUES (?, ?, ?, ?)`
+	deleteBlocksSQL    = `DELETE FROM blocks WHERE file = ?`
+	selectPreamblesSQL = `SELECT text FROM blocks WHERE type = 'preamble' GROUP BY text ORDER BY MIN(id)`
+	// the entries failing to parse or build in the last import of their files, as they are in the files
+	createRejectedTableSQL = `CREATE TABLE IF NOT EXISTS rejected(
+            id INTEGER PRIMARY KEY,
+            cite_name TEXT DEFAULT "",
+            file TEXT NOT NULL,
+            line INTEGER NOT NULL,
+            text TEXT NOT NULL,
+            reason TEXT NOT NULL
+        );`
+	insertRejectedSQL = `INSERT INTO rejected (cite_name, file, line, text, reason) VALUES (?, ?, ?, ?, ?)`
+	deleteRejectedSQL = `DELETE FROM rejected WHERE file = ?`
+	selectRejectedSQL = `SELECT cite_name, file, line, text, reason FROM rejected ORDER BY file, line`
+	// the generation of the entries is bumped by entryWriter whenever it changes them
+	createGenerationTableSQL = `CREATE TABLE IF NOT EXISTS generation(value INTEGER NOT NULL);
+        INSERT INTO generation (value) SELECT 0 WHERE NOT EXISTS (SELECT * FROM generation);`
+	bumpGenerationSQL   = `UPDATE generation SET value = value + 1`
+	selectGenerationSQL = `SELECT value FROM generation`
+	// the search index is rebuilt when the generation of the entries changes, rather than by triggers,
+	// so that the database works with the builds without FTS5 as well
+	createSearchIndexSQL = `CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5(
+            title, author, venue, note, metanote,
+            tokenize = 'unicode61 remove_diacritics 2'
+        );
+        CREATE TABLE IF NOT EXISTS search_state(generation INTEGER NOT NULL);`
+	rebuildSearchIndexSQL = `DELETE FROM entries_fts;
+        INSERT INTO entries_fts (rowid, title, author, venue, note, metanote)
+            SELECT id, ` + searchableTitle + `, ` + searchableAuthor + `, ` + searchableVenue + `, ` + searchableNote + `, ` + searchableMetanote + ` FROM entries;
+        DELETE FROM search_state;`
+	searchableTitle    = `replace(replace(title, '(TODO)', ''), '(OPTIONAL)', '')`
+	searchableAuthor   = `replace(replace(author, '(TODO)', ''), '(OPTIONAL)', '')`
+	searchableVenue    = `replace(replace(journal || ' ' || booktitle, '(TODO)', ''), '(OPTIONAL)', '')`
+	searchableNote     = `replace(replace(note, '(TODO)', ''), '(OPTIONAL)', '')`
+	searchableMetanote = `replace(replace(metanote, '(TODO)', ''), '(OPTIONAL)', '')`
+	selectEntrySQL     = `SELECT cite_name, cite_type, title, author, booktitle, doi, edition, isbn, issn, institution, journal, metanote, note, number, numpages, pages, publisher, school, series, type, url, version, volume, year, address, chapter, editor, eprint, howpublished, month, organization, urldate FROM entries`
+)
+
+// addedEntryColumns are the columns added to the entries after the first release, which
+// the databases created before are migrated to have
+var addedEntryColumns = []string{"address", "chapter", "editor", "eprint", "howpublished", "month", "organization", "urldate"}
+
+// entryStatus is the result of storing an entry
+type entryStatus int
+
+const (
+	entryDuplicate entryStatus = iota
+	entryAdded
+	entryUpdated
+)
+
+// rowScanner is either *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func createDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range []string{createTableSQL, createStringsTableSQL, createTagsTableSQL, createSourcesTableSQL, createBlocksTableSQL, createRejectedTableSQL, createGenerationTableSQL} {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := migrateEntries(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateEntries adds the columns missing in the entries of an older database
+func migrateEntries(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(entries)")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range addedEntryColumns {
+		if existing[column] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE entries ADD COLUMN ` + column + ` TEXT DEFAULT ""`); err != nil {
+			return fmt.Errorf("adding %s to the entries: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// loadStrings returns the string variables stored in db
+func loadStrings(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(selectStringsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, rows.Err()
+}
+
+// loadPreambles returns the preambles stored in db in the order imported, without the duplicates
+func loadPreambles(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(selectPreamblesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var preambles []string
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		preambles = append(preambles, text)
+	}
+	return preambles, rows.Err()
+}
+
+// rejectedEntry is an entry failing to parse or build with the reason
+type rejectedEntry struct {
+	Key    string `json:"key"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"` // the entry as it is in the file
+	Reason string `json:"reason"`
+}
+
+// loadRejected returns the entries rejected in db by the file and the line
+func loadRejected(db *sql.DB) ([]rejectedEntry, error) {
+	rows, err := db.Query(selectRejectedSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rejects []rejectedEntry
+	for rows.Next() {
+		var re rejectedEntry
+		if err := rows.Scan(&re.Key, &re.File, &re.Line, &re.Text, &re.Reason); err != nil {
+			return nil, err
+		}
+		rejects = append(rejects, re)
+	}
+	return rejects, rows.Err()
+}
+
+// storeRejected replaces the entries rejected in the files with rejects in a transaction of its own,
+// so that they are kept even if the import is rolled back
+func storeRejected(db *sql.DB, files []string, rejects []rejectedEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := tx.Exec(deleteRejectedSQL, file); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, re := range rejects {
+		if _, err := tx.Exec(insertRejectedSQL, re.Key, re.File, re.Line, re.Text, re.Reason); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadTags returns the tags of the entries in db by the cite name
+func loadTags(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(selectTagsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var citeName, tag string
+		if err := rows.Scan(&citeName, &tag); err != nil {
+			return nil, err
+		}
+		tags[citeName] = append(tags[citeName], tag)
+	}
+	return tags, rows.Err()
+}
+
+// source is the file an entry was imported from, with the entry as of the last sync
+type source struct {
+	file   string
+	line   int
+	synced bibfuse.BibItem
+}
+
+// loadSources returns the sources of the entries in db by the cite name
+func loadSources(db *sql.DB) (map[string]source, error) {
+	rows, err := db.Query(selectSourcesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make(map[string]source)
+	for rows.Next() {
+		var citeName, synced string
+		var src source
+		if err := rows.Scan(&citeName, &src.file, &src.line, &synced); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(synced), &src.synced); err != nil {
+			return nil, fmt.Errorf("[%s] source: %w", citeName, err)
+		}
+		sources[citeName] = src
+	}
+	return sources, rows.Err()
+}
+
+// entryWriter stores entries in a transaction with the prepared statements
+type entryWriter struct {
+	tx           *sql.Tx
+	insert       *sql.Stmt
+	update       *sql.Stmt
+	find         *sql.Stmt
+	insertString *sql.Stmt
+	upsertString *sql.Stmt
+	insertTag    *sql.Stmt
+	insertSource *sql.Stmt
+	upsertSource *sql.Stmt
+	insertBlock  *sql.Stmt
+	deleteBlocks *sql.Stmt
+	changed      bool // the entries are changed, and the generation is bumped on commit
+}
+
+func newEntryWriter(db *sql.DB) (*entryWriter, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	w := &entryWriter{tx: tx}
+	for _, prepare := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.insert, insertEntrySQL},
+		{&w.update, updateEntrySQL},
+		{&w.find, selectEntrySQL + " WHERE cite_name = ?"},
+		{&w.insertString, insertStringSQL},
+		{&w.upsertString, upsertStringSQL},
+		{&w.insertTag, insertTagSQL},
+		{&w.insertSource, insertSourceSQL},
+		{&w.upsertSource, upsertSourceSQL},
+		{&w.insertBlock, insertBlockSQL},
+		{&w.deleteBlocks, deleteBlocksSQL},
+	} {
+		if *prepare.stmt, err = tx.Prepare(prepare.query); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// commit bumps the generation if the entries are changed, and commits the transaction,
+// the statements are closed with it
+func (w *entryWriter) commit() error {
+	if w.changed {
+		if _, err := w.tx.Exec(bumpGenerationSQL); err != nil {
+			w.tx.Rollback()
+			return err
+		}
+	}
+	return w.tx.Commit()
+}
+
+// rollback discards the transaction
+func (w *entryWriter) rollback() error {
+	return w.tx.Rollback()
+}
+
+func (w *entryWriter) insertEntry(bi bibfuse.BibItem) (entryStatus, error) {
+	res, err := w.insert.Exec(
+		bi.CiteName,
+		bi.CiteType,
+		bi.Title,
+		bi.Author,
+		bi.Booktitle,
+		bi.DOI,
+		bi.Edition,
+		bi.ISBN,
+		bi.ISSN,
+		bi.Institution,
+		bi.Journal,
+		bi.Metanote,
+		bi.Note,
+		bi.Number,
+		bi.Numpages,
+		bi.Pages,
+		bi.Publisher,
+		bi.School,
+		bi.Series,
+		bi.URL,
+		bi.TechreportType,
+		bi.Version,
+		bi.Volume,
+		bi.Year,
+		bi.Address,
+		bi.Chapter,
+		bi.Editor,
+		bi.Eprint,
+		bi.Howpublished,
+		bi.Month,
+		bi.Organization,
+		bi.URLDate,
+	)
+	if err != nil {
+		return entryDuplicate, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return entryDuplicate, err
+	}
+	if affected > 0 {
+		w.changed = true
+		return entryAdded, nil
+	}
+	return entryDuplicate, nil
+}
+
+// upsertEntry inserts the entry or updates the existing one if it differs
+func (w *entryWriter) upsertEntry(bi bibfuse.BibItem) (entryStatus, error) {
+	existing, err := scanEntry(w.find.QueryRow(bi.CiteName))
+	if err == sql.ErrNoRows {
+		return w.insertEntry(bi)
+	}
+	if err != nil {
+		return entryDuplicate, err
+	}
+	if existing == bi {
+		return entryDuplicate, nil
+	}
+	if _, err := w.update.Exec(
+		bi.CiteType,
+		bi.Title,
+		bi.Author,
+		bi.Booktitle,
+		bi.DOI,
+		bi.Edition,
+		bi.ISBN,
+		bi.ISSN,
+		bi.Institution,
+		bi.Journal,
+		bi.Metanote,
+		bi.Note,
+		bi.Number,
+		bi.Numpages,
+		bi.Pages,
+		bi.Publisher,
+		bi.School,
+		bi.Series,
+		bi.URL,
+		bi.TechreportType,
+		bi.Version,
+		bi.Volume,
+		bi.Year,
+		bi.Address,
+		bi.Chapter,
+		bi.Editor,
+		bi.Eprint,
+		bi.Howpublished,
+		bi.Month,
+		bi.Organization,
+		bi.URLDate,
+		bi.CiteName,
+	); err != nil {
+		return entryDuplicate, err
+	}
+	w.changed = true
+	return entryUpdated, nil
+}
+
+// storeString stores the string variable defined in file, the existing one is replaced only if update
+func (w *entryWriter) storeString(name, value, file string, update bool) error {
+	stmt := w.insertString
+	if update {
+		stmt = w.upsertString
+	}
+	_, err := stmt.Exec(name, value, file)
+	return err
+}
+
+// addTags tags the entry
+func (w *entryWriter) addTags(citeName string, tags []string) error {
+	for _, tag := range tags {
+		if _, err := w.insertTag.Exec(citeName, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackSource records the file and line the entry came from, the existing record
+// is replaced only if update
+func (w *entryWriter) trackSource(bi bibfuse.BibItem, file string, line int, update bool) error {
+	synced, err := json.Marshal(bi)
+	if err != nil {
+		return err
+	}
+	stmt := w.insertSource
+	if update {
+		stmt = w.upsertSource
+	}
+	_, err = stmt.Exec(bi.CiteName, file, line, string(synced))
+	return err
+}
+
+// storeBlocks stores the preambles, the comments, and the text between the blocks in the file,
+// the ones stored from the file before are replaced only if update
+func (w *entryWriter) storeBlocks(file string, blocks []bibfuse.Block, update bool) error {
+	if update {
+		if _, err := w.deleteBlocks.Exec(file); err != nil {
+			return err
+		}
+	}
+	for _, block := range blocks {
+		blockType := block.Type
+		switch {
+		case blockType == "preamble" || blockType == "comment":
+		case blockType == "" && strings.TrimSpace(block.Text) != "":
+			blockType = "text"
+		default:
+			continue
+		}
+		// the line of the text rather than of the newlines before it
+		text := strings.TrimSpace(block.Text)
+		line := block.Line + strings.Count(block.Text[:strings.Index(block.Text, text)], "\n")
+		if _, err := w.insertBlock.Exec(blockType, text, file, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findEntry returns the entry stored as citeName
+func (w *entryWriter) findEntry(citeName string) (*bibtex.BibEntry, bool) {
+	bi, err := scanEntry(w.find.QueryRow(citeName))
+	if err != nil {
+		return nil, false
+	}
+	return bi.ToBibEntry(), true
+}
+
+// scanEntry reads a row of selectEntrySQL
+func scanEntry(row rowScanner) (bibfuse.BibItem, error) {
+	bi := bibfuse.NewBibItem()
+	err := row.Scan(
+		&bi.CiteName,
+		&bi.CiteType,
+		&bi.Title,
+		&bi.Author,
+		&bi.Booktitle,
+		&bi.DOI,
+		&bi.Edition,
+		&bi.ISBN,
+		&bi.ISSN,
+		&bi.Institution,
+		&bi.Journal,
+		&bi.Metanote,
+		&bi.Note,
+		&bi.Number,
+		&bi.Numpages,
+		&bi.Pages,
+		&bi.Publisher,
+		&bi.School,
+		&bi.Series,
+		&bi.TechreportType,
+		&bi.URL,
+		&bi.Version,
+		&bi.Volume,
+		&bi.Year,
+		&bi.Address,
+		&bi.Chapter,
+		&bi.Editor,
+		&bi.Eprint,
+		&bi.Howpublished,
+		&bi.Month,
+		&bi.Organization,
+		&bi.URLDate,
+	)
+	return bi, err
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+// createEntriesBeforeSQL is the entries of the databases created before addedEntryColumns
+const createEntriesBeforeSQL = `CREATE TABLE entries(
+            id INTEGER PRIMARY KEY,
+            cite_name TEXT UNIQUE NOT NULL,
+            cite_type TEXT NOT NULL,
+            author TEXT DEFAULT "",
+            title TEXT DEFAULT "",
+            booktitle TEXT DEFAULT "",
+            doi TEXT DEFAULT "",
+            edition TEXT DEFAULT "",
+            isbn TEXT DEFAULT "",
+            issn TEXT DEFAULT "",
+            institution TEXT DEFAULT "",
+            journal TEXT DEFAULT "",
+            metanote TEXT DEFAULT "",
+            note TEXT DEFAULT "",
+            number TEXT DEFAULT "",
+            numpages TEXT DEFAULT "",
+            pages TEXT DEFAULT "",
+            publisher TEXT DEFAULT "",
+            school TEXT DEFAULT "",
+            series TEXT DEFAULT "",
+            type TEXT DEFAULT "",
+            url TEXT DEFAULT "",
+            version TEXT DEFAULT "",
+            volume TEXT DEFAULT "",
+            year TEXT
+        );`
+
+func TestMigrateEntries(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bib.db")
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(createEntriesBeforeSQL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`INSERT INTO entries (cite_name, cite_type, title, year) VALUES ('old2019', 'misc', 'Old', '2019')`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := createDB(dbPath)
+	if err != nil {
+		t.Fatalf("createDB() err => %v, want nil", err)
+	}
+	db.Close()
+	// the columns are added only once
+	if db, err = createDB(dbPath); err != nil {
+		t.Fatalf("createDB() of the migrated database err => %v, want nil", err)
+	}
+	defer db.Close()
+
+	findEntry := func(citeName string) []string {
+		t.Helper()
+		bi, err := scanEntry(db.QueryRow(selectEntrySQL+" WHERE cite_name = ?", citeName))
+		if err != nil {
+			t.Fatalf("scanEntry(%v) err => %v, want nil", citeName, err)
+		}
+		values := make([]string, len(addedEntryColumns))
+		for i, column := range addedEntryColumns {
+			values[i], _ = bi.FieldValueByBibTexName(column)
+		}
+		if bi.Title == "" || bi.Year == "" {
+			t.Errorf("scanEntry(%v) => %v, want the title and the year", citeName, bi)
+		}
+		return values
+	}
+
+	// the entries stored before have the columns empty
+	if values := findEntry("old2019"); !reflect.DeepEqual(values, make([]string, len(addedEntryColumns))) {
+		t.Errorf("the added columns of old2019 => %q, want empty", values)
+	}
+
+	fields := []string{"title", "New", "year", "2021"}
+	want := make([]string, len(addedEntryColumns))
+	for i, column := range addedEntryColumns {
+		want[i] = "the " + column
+		fields = append(fields, column, want[i])
+	}
+	insertTestEntries(t, db, newTestEntry(t, "new2021", "manual", fields...))
+	if values := findEntry("new2021"); !reflect.DeepEqual(values, want) {
+		t.Errorf("the added columns of new2021 => %q, want %q", values, want)
+	}
+}
+
+func TestStoreRejected(t *testing.T) {
+	db := openTestDB(t)
+	a := rejectedEntry{Key: "a", File: "a.bib", Line: 3, Text: "@misc{a, author = {Smith, J}}", Reason: "no dot in abbreviation: J"}
+	b1 := rejectedEntry{Key: "", File: "b.bib", Line: 1, Text: "@misc{", Reason: "syntax error"}
+	b2 := rejectedEntry{Key: "b", File: "b.bib", Line: 9, Text: "@misc{b, author = {J.}}", Reason: "last name should not be abbreviated: J."}
+	if err := storeRejected(db, []string{"a.bib", "b.bib"}, []rejectedEntry{b2, a, b1}); err != nil {
+		t.Fatal(err)
+	}
+	rejects, err := loadRejected(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []rejectedEntry{a, b1, b2}; !reflect.DeepEqual(rejects, want) {
+		t.Errorf("loadRejected() => %v, want %v", rejects, want)
+	}
+
+	// the entries rejected in the files imported again are replaced, the others kept
+	a.Line = 5
+	for _, tt := range []struct {
+		files   []string
+		rejects []rejectedEntry
+		want    []rejectedEntry
+	}{
+		{[]string{"a.bib"}, []rejectedEntry{a}, []rejectedEntry{a, b1, b2}},
+		{[]string{"b.bib"}, nil, []rejectedEntry{a}},
+	} {
+		if err := storeRejected(db, tt.files, tt.rejects); err != nil {
+			t.Fatal(err)
+		}
+		if rejects, err = loadRejected(db); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rejects, tt.want) {
+			t.Errorf("storeRejected(%v) => %v, want %v", tt.files, rejects, tt.want)
+		}
+	}
+}
+
+// storeTestBlocks stores the blocks of the source as the file in a transaction
+func storeTestBlocks(t *testing.T, db *sql.DB, file, src string, update bool) {
+	t.Helper()
+	w, err := newEntryWriter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.storeBlocks(file, bibfuse.SplitBlocks([]byte(src)), update); err != nil {
+		w.rollback()
+		t.Fatal(err)
+	}
+	if err := w.commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedBlock is a row of the blocks
+type storedBlock struct {
+	Type, Text, File string
+	Line             int
+}
+
+func TestStoreBlocks(t *testing.T) {
+	db := openTestDB(t)
+	storeTestBlocks(t, db, "a.bib", "% my references\n@preamble{\"\\noop\"}\n\n@misc{a, title = {A}}\nnotes\n@comment{jabref-meta: databaseType:bibtex;}\n@string{acm = \"ACM\"}\n", false)
+
+	rows, err := db.Query("SELECT type, text, file, line FROM blocks ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var blocks []storedBlock
+	for rows.Next() {
+		var block storedBlock
+		if err := rows.Scan(&block.Type, &block.Text, &block.File, &block.Line); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	// the entries and the string variables are stored in their own tables
+	want := []storedBlock{
+		{"text", "% my references", "a.bib", 1},
+		{"preamble", `@preamble{"\noop"}`, "a.bib", 2},
+		{"text", "notes", "a.bib", 5},
+		{"comment", "@comment{jabref-meta: databaseType:bibtex;}", "a.bib", 6},
+	}
+	if !reflect.DeepEqual(blocks, want) {
+		t.Errorf("storeBlocks() => %v, want %v", blocks, want)
+	}
+}
+
+func TestLoadPreambles(t *testing.T) {
+	db := openTestDB(t)
+	storeTestBlocks(t, db, "a.bib", "@preamble{\"\\a\"}\n@preamble{\"\\b\"}\n", false)
+	storeTestBlocks(t, db, "b.bib", "@preamble{\"\\a\"}\n@preamble{\"\\c\"}\n", false)
+	for _, tt := range []struct {
+		file   string
+		src    string
+		update bool
+		want   []string
+	}{
+		// the preambles in the order imported without the duplicates
+		{"", "", false, []string{`@preamble{"\a"}`, `@preamble{"\b"}`, `@preamble{"\c"}`}},
+		// the preambles are kept without -update
+		{"a.bib", "@misc{a}\n", false, []string{`@preamble{"\a"}`, `@preamble{"\b"}`, `@preamble{"\c"}`}},
+		// and replaced with it
+		{"a.bib", "@misc{a}\n", true, []string{`@preamble{"\a"}`, `@preamble{"\c"}`}},
+	} {
+		if tt.file != "" {
+			storeTestBlocks(t, db, tt.file, tt.src, tt.update)
+		}
+		preambles, err := loadPreambles(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(preambles, tt.want) {
+			t.Errorf("loadPreambles() after %v => %q, want %q", tt.file, preambles, tt.want)
+		}
+	}
+}
+
+// entryGeneration returns the generation of the entries
+func entryGeneration(t *testing.T, db *sql.DB) int64 {
+	t.Helper()
+	var generation int64
+	if err := db.QueryRow(selectGenerationSQL).Scan(&generation); err != nil {
+		t.Fatal(err)
+	}
+	return generation
+}
+
+func TestEntryGeneration(t *testing.T) {
+	db := openTestDB(t)
+	if generation := entryGeneration(t, db); generation != 0 {
+		t.Fatalf("the generation of a new database => %d, want 0", generation)
+	}
+	a := newTestEntry(t, "a", "misc", "title", "A", "year", "2020")
+	updated := newTestEntry(t, "a", "misc", "title", "A2", "year", "2020")
+	for _, tt := range []struct {
+		name     string
+		write    func(w *entryWriter) error
+		rollback bool
+		want     int64
+	}{
+		{"inserted", func(w *entryWriter) error { _, err := w.insertEntry(a); return err }, false, 1},
+		{"duplicate", func(w *entryWriter) error { _, err := w.insertEntry(a); return err }, false, 1},
+		{"unchanged", func(w *entryWriter) error { _, err := w.upsertEntry(a); return err }, false, 1},
+		{"updated", func(w *entryWriter) error { _, err := w.upsertEntry(updated); return err }, false, 2},
+		{"rolled back", func(w *entryWriter) error { _, err := w.upsertEntry(a); return err }, true, 2},
+		{"tagged", func(w *entryWriter) error { return w.addTags("a", []string{"rfid"}) }, false, 2},
+	} {
+		w, err := newEntryWriter(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.write(w); err != nil {
+			w.rollback()
+			t.Fatal(err)
+		}
+		if tt.rollback {
+			err = w.rollback()
+		} else {
+			err = w.commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generation := entryGeneration(t, db); generation != tt.want {
+			t.Errorf("the generation after the entry %s => %d, want %d", tt.name, generation, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+)
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	opts := options{
+		expandStrings:  true,
+		jobs:           1,
+		venuesFile:     defaultVenuesFile,
+		titleWordsFile: defaultTitleWordsFile,
+	}
+	fs.StringVar(&opts.config, "config", defaultConfigFile, "The bibfuse.[toml|yml] defining the filters.")
+	fs.BoolVar(&opts.smart, "smart", false, "Use oneof selectively filters when importing bibtex.")
+	dbs := fs.Bool("db", false, "Compare two SQLite files instead of .bib files.")
+	bindReportFlags(fs, &opts.format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s diff: [options] old.bib new.bib\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s diff [options] -db old.db new.db\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Show the entries added, removed, renamed, and changed field by field, ignoring the formatting.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.useDefaultConfig = opts.config == defaultConfigFile
+
+	versions := make([][]bibfuse.BibItem, 2)
+	var err error
+	if *dbs {
+		for i, path := range fs.Args() {
+			if versions[i], err = readDBVersion(path); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := configureViper(opts); err != nil {
+			return err
+		}
+		filters, oneofs, err := loadRules()
+		if err != nil {
+			return err
+		}
+		if err := loadNormalizers(&opts); err != nil {
+			return err
+		}
+		for i, path := range fs.Args() {
+			if versions[i], err = readVersion(path, filters, oneofs, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	diffs := bibfuse.DiffBibliographies(versions[0], versions[1])
+	if opts.format.isJSON() {
+		if diffs == nil {
+			diffs = []bibfuse.EntryDiff{}
+		}
+		if err := printJSON(diffs); err != nil {
+			return err
+		}
+	} else {
+		fmt.Print(formatDiffs(diffs))
+	}
+	log.Printf("%d entries differ", len(diffs))
+	return nil
+}
+
+// readDBVersion returns the entries in the SQLite file, which has to exist
+func readDBVersion(path string) ([]bibfuse.BibItem, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := createDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer db.Close()
+	return queryEntries(db, selectEntrySQL+" ORDER BY cite_name ASC")
+}
+
+// formatDiffs returns the diffs an entry per line, followed by its changes indented
+func formatDiffs(diffs []bibfuse.EntryDiff) string {
+	var sb strings.Builder
+	for _, diff := range diffs {
+		switch diff.Status {
+		case bibfuse.DiffRenamed:
+			fmt.Fprintf(&sb, "%-8s %s -> %s\n", diff.Status, diff.OldKey, diff.Key)
+		default:
+			fmt.Fprintf(&sb, "%-8s %s\n", diff.Status, diff.Key)
+		}
+		for _, change := range diff.Changes {
+			fmt.Fprintf(&sb, "    %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+	}
+	return sb.String()
+}
+
+// readVersion builds the entries in a version of the file, a version with invalid entries
+// fails the diff rather than dropping them
+func readVersion(path string, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options) ([]bibfuse.BibItem, error) {
+	parsed := parseBibFiles(nil, nil, filters, oneofs, opts, []string{path})[0]
+	if parsed.err != nil {
+		return nil, fmt.Errorf("%s: %w", parsed.path, parsed.err)
+	}
+	if len(parsed.invalid) > 0 {
+		for _, err := range parsed.invalid {
+			logProblem(levelError, parsed, err)
+		}
+		return nil, fmt.Errorf("%s: %d invalid entries", parsed.path, len(parsed.invalid))
+	}
+	return parsed.items, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+	"github.com/nickng/bibtex"
+)
+
+// diffContext is the number of the lines around the changes in fmt -d
+const diffContext = 3
+
+// fmtReport is the result of formatting a file for -format json
+type fmtReport struct {
+	File    string   `json:"file"`
+	Changed bool     `json:"changed"`
+	Written bool     `json:"written"`
+	Invalid []string `json:"invalid"`           // the entries kept as they are
+	Diff    string   `json:"diff,omitempty"`    // with -d
+	Content string   `json:"content,omitempty"` // without -w or -d
+}
+
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	opts := options{tolerant: true}
+	bindWriteFlags(fs, &opts)
+	sortSpec := fs.String("sort", "", "Sort the entries by the keys (author, key, title, type, or year, followed by asc or desc), or keep the order.")
+	write := fs.Bool("w", false, "Write the result to the file instead of stdout.")
+	showDiff := fs.Bool("d", false, "Print the diffs instead of the result.")
+	bindReportFlags(fs, &opts.format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s fmt: [options] .bib ... .bib\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Rewrite the entries in the style of the resulting bibtex with the fields and values as they are")
+		fmt.Fprintln(os.Stderr, "but the author and editor names normalized, in the fixed order of title, author, url, and the")
+		fmt.Fprintln(os.Stderr, "rest alphabetically. The comments, @preamble, @string, and the entries failing to parse or with")
+		fmt.Fprintln(os.Stderr, "# concatenations are kept as they are.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var err error
+	if opts.sortSpec, err = bibfuse.ParseSortSpec(*sortSpec); err != nil {
+		return err
+	}
+	if err := loadWriteOptions(&opts); err != nil {
+		return err
+	}
+
+	reports := []fmtReport{}
+	for _, path := range fs.Args() {
+		parsed := readBibFile(path)
+		if parsed.err != nil {
+			return parsed.err
+		}
+		parseBibFile(&parsed, collectStrings([]parsedFile{parsed}, nil), opts)
+		report := fmtReport{File: parsed.path, Invalid: []string{}}
+		for _, err := range parsed.invalid {
+			log.Printf("%v (kept as it is)", err)
+			report.Invalid = append(report.Invalid, err.Error())
+		}
+		blocks, err := formatBibBlocks(parsed, opts)
+		if err != nil {
+			return err
+		}
+		formatted := strings.Join(blocks, "")
+		report.Changed = !bytes.Equal(parsed.data, []byte(formatted))
+
+		switch {
+		case *showDiff:
+			var original []string
+			for _, block := range bibfuse.SplitBlocks(parsed.data) {
+				original = append(original, block.Text)
+			}
+			report.Diff = unifiedDiff(parsed.path, original, blocks)
+			if !opts.format.isJSON() {
+				fmt.Print(report.Diff)
+			}
+		case *write:
+			if report.Changed {
+				info, err := os.Stat(parsed.path)
+				if err != nil {
+					return err
+				}
+				if err := writeFileAtomic(parsed.path, []byte(formatted), info.Mode().Perm()); err != nil {
+					return err
+				}
+				report.Written = true
+				log.Printf("%s formatted", parsed.path)
+			}
+		default:
+			report.Content = formatted
+			if !opts.format.isJSON() {
+				fmt.Print(formatted)
+			}
+		}
+		reports = append(reports, report)
+	}
+	if opts.format.isJSON() {
+		return printJSON(reports)
+	}
+	return nil
+}
+
+// formatBibBlocks returns the blocks of the file with the entries parsed written in the style of
+// opts.writeOptions, in the order of opts.sortSpec, and the rest of the blocks as they are
+func formatBibBlocks(parsed parsedFile, opts options) ([]string, error) {
+	// the entries failing to parse have no lines to tell them from the duplicates
+	failed := make(map[int]bool)
+	for _, err := range parsed.invalid {
+		var parseErr *bibfuse.ParseError
+		if errors.As(err, &parseErr) {
+			failed[parseErr.Line] = true
+		}
+	}
+	parsedEntries := make(map[string][]*bibtex.BibEntry)
+	for _, entry := range parsed.bib.Entries {
+		parsedEntries[entry.CiteName] = append(parsedEntries[entry.CiteName], entry)
+	}
+
+	// the entries parsed are sorted among their blocks, the others stay where they are
+	blocks := bibfuse.SplitBlocks(parsed.data)
+	var slots []int
+	var entries []*bibtex.BibEntry
+	for i, block := range blocks {
+		key := block.Key()
+		if !block.IsEntry() || failed[block.Line] || len(parsedEntries[key]) == 0 {
+			continue
+		}
+		if bibfuse.HasConcatenation(block.Text) {
+			// the parts after the first are lost in parsing
+			parsedEntries[key] = parsedEntries[key][1:]
+			continue
+		}
+		slots = append(slots, i)
+		entries = append(entries, parsedEntries[key][0])
+		parsedEntries[key] = parsedEntries[key][1:]
+	}
+	for _, entry := range entries {
+		normalizeNames(entry)
+	}
+	opts.sortSpec.SortEntries(entries)
+
+	texts := make(map[int]string, len(slots))
+	for i, slot := range slots {
+		var sb strings.Builder
+		if err := bibfuse.NewWriter(&sb, opts.writeOptions).WriteEntry(entries[i]); err != nil {
+			return nil, err
+		}
+		texts[slot] = strings.TrimRight(sb.String(), "\n")
+	}
+
+	formatted := make([]string, len(blocks))
+	for i, block := range blocks {
+		if text, ok := texts[i]; ok {
+			formatted[i] = text
+		} else {
+			formatted[i] = block.Text
+		}
+	}
+	return formatted, nil
+}
+
+// normalizeNames writes the names in the author and editor fields of the entry as they are
+// stored, e.g., `Doe,  Jane and J. Smith` to `Doe, Jane and J. Smith`, the names failing
+// to build (e.g., an initial without a dot) are kept as they are
+func normalizeNames(entry *bibtex.BibEntry) {
+	for _, field := range []string{"author", "editor"} {
+		value, ok := entry.Fields[field].(bibtex.BibConst)
+		if !ok {
+			continue
+		}
+		authors, err := bibfuse.NewAuthors(strings.Join(strings.Fields(string(value)), " "))
+		if err != nil {
+			continue
+		}
+		entry.Fields[field] = bibtex.NewBibConst(authors.String())
+	}
+}
+
+// unifiedDiff returns the diff from the pieces of a to the ones of b in the unified format, or an
+// empty string if they are equal; the pieces, e.g., the blocks, are aligned and diffed one by one,
+// joined until both end at a line
+func unifiedDiff(path string, a, b []string) string {
+	if strings.Join(a, "") == strings.Join(b, "") {
+		return ""
+	}
+	var ops []lineOp
+	var aHunk, bHunk string
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i < len(a) {
+			aHunk += a[i]
+		}
+		if i < len(b) {
+			bHunk += b[i]
+		}
+		if i+1 < len(a) && i+1 < len(b) && (!strings.HasSuffix(aHunk, "\n") || !strings.HasSuffix(bHunk, "\n")) {
+			continue
+		}
+		ops = append(ops, diffLines(splitLines(aHunk), splitLines(bHunk))...)
+		aHunk, bHunk = "", ""
+	}
+	// the line numbers in a and b before each op
+	aLines, bLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if op.kind != '+' {
+			aLines[i+1]++
+		}
+		if op.kind != '-' {
+			bLines[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff -u %s.orig %s\n--- %s.orig\n+++ %s\n", path, path, path, path)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// a hunk continues while the changes are closer than the context on both sides
+		start, end := i-diffContext, i+1
+		if start < 0 {
+			start = 0
+		}
+		for j := i + 1; j < len(ops) && j-end < 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			}
+		}
+		if end += diffContext; end > len(ops) {
+			end = len(ops)
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aLines[start]+1, aLines[end]-aLines[start], bLines[start]+1, bLines[end]-bLines[start])
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			if !strings.HasSuffix(op.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+// writeTestFile writes the source to refs.bib in a temporary directory, and returns the path
+// relative to the working directory as the files are given
+func writeTestFile(t *testing.T, src string) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := filepath.Rel(wd, filepath.Join(t.TempDir(), "refs.bib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// formatTestFile formats the source written to a file in a temporary directory
+func formatTestFile(t *testing.T, src, sortSpec string) string {
+	t.Helper()
+	var err error
+	opts := options{tolerant: true, writeOptions: bibfuse.DefaultWriteOptions()}
+	if opts.sortSpec, err = bibfuse.ParseSortSpec(sortSpec); err != nil {
+		t.Fatal(err)
+	}
+	parsed := readBibFile(writeTestFile(t, src))
+	if parsed.err != nil {
+		t.Fatal(parsed.err)
+	}
+	parseBibFile(&parsed, collectStrings([]parsedFile{parsed}, nil), opts)
+	blocks, err := formatBibBlocks(parsed, opts)
+	if err != nil {
+		t.Fatalf("formatBibBlocks(%q) err => %v, want nil", src, err)
+	}
+	return strings.Join(blocks, "")
+}
+
+var formatbibfiletests = []struct {
+	in   string
+	sort string
+	out  string
+}{
+	{
+		// the fields outside the filters are kept and no placeholders are added
+		"@article{a2019,\n  author={J Smith}, title = {A},\n  abstract = {An abstract.}, keywords = {x, y},\n  file = {:a.pdf:PDF}, month = oct, year = 2019\n}\n",
+		"",
+		"@article{a2019,\n    title    = \"A\",\n    author   = \"J Smith\",\n    abstract = \"An abstract.\",\n    file     = \":a.pdf:PDF\",\n    keywords = \"x, y\",\n    month    = oct,\n    year     = 2019,\n}\n",
+	},
+	{
+		// the strings, comments, preambles, broken entries, and concatenations stay as they are
+		"@string{acm = \"ACM\"}\n% a comment\n@preamble{\"\\noop\"}\n\n@misc{b, publisher = acm}\n\n@misc{broken,\n  title = {Broken\n\n@misc{c, note = \"Vol. \" # acm}\n",
+		"",
+		"@string{acm = \"ACM\"}\n% a comment\n@preamble{\"\\noop\"}\n\n@misc{b,\n    publisher = acm,\n}\n\n@misc{broken,\n  title = {Broken\n\n@misc{c, note = \"Vol. \" # acm}\n",
+	},
+	{
+		// the names are normalized unless they fail to build
+		"@misc{a, author = {John Smith and Doe,  Jane}, editor = {Roe,\n   Jane and others}}\n\n@misc{b, author = {Smith, J and Doe, Jane}}\n",
+		"",
+		"@misc{a,\n    author = \"John Smith and Doe, Jane\",\n    editor = \"Roe, Jane and others\",\n}\n\n@misc{b,\n    author = \"Smith, J and Doe, Jane\",\n}\n",
+	},
+	{
+		// the entries are sorted among their blocks
+		"@misc{c, year = 2019}\n% between\n@misc{a, year = 2021}\n\n@misc{b, year = 2020}\n",
+		"key",
+		"@misc{a,\n    year = 2021,\n}\n% between\n@misc{b,\n    year = 2020,\n}\n\n@misc{c,\n    year = 2019,\n}\n",
+	},
+	{
+		"@misc{c, year = 2019}\n% between\n@misc{a, year = 2021}\n\n@misc{b, year = 2020}\n",
+		"year desc",
+		"@misc{a,\n    year = 2021,\n}\n% between\n@misc{b,\n    year = 2020,\n}\n\n@misc{c,\n    year = 2019,\n}\n",
+	},
+}
+
+func TestFormatBibFile(t *testing.T) {
+	for _, tt := range formatbibfiletests {
+		if out := formatTestFile(t, tt.in, tt.sort); out != tt.out {
+			t.Errorf("formatBibBlocks(%q, %q) => %q, want %q", tt.in, tt.sort, out, tt.out)
+		}
+		// formatting is idempotent
+		if out := formatTestFile(t, tt.out, tt.sort); out != tt.out {
+			t.Errorf("formatBibBlocks(%q, %q) => %q, want %q", tt.out, tt.sort, out, tt.out)
+		}
+	}
+}
+
+var unifieddifftests = []struct {
+	a, b []string
+	out  string
+}{
+	{
+		[]string{"a\nb\n"},
+		[]string{"a\nb\n"},
+		"",
+	},
+	{
+		[]string{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"},
+		[]string{"1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n"},
+		"diff -u refs.bib.orig refs.bib\n--- refs.bib.orig\n+++ refs.bib\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+	},
+	{
+		[]string{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"},
+		[]string{"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\nfifteen\n"},
+		"diff -u refs.bib.orig refs.bib\n--- refs.bib.orig\n+++ refs.bib\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -12,4 +12,4 @@\n 12\n 13\n 14\n-15\n+fifteen\n",
+	},
+	{
+		[]string{"a\nb"},
+		[]string{"a\nc\n"},
+		"diff -u refs.bib.orig refs.bib\n--- refs.bib.orig\n+++ refs.bib\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n",
+	},
+	// the pieces are diffed one by one, joined until both end at a line
+	{
+		[]string{"1\n2", "\n3\n", "4\n5", "\n"},
+		[]string{"1\ntwo", "\n3\n", "4\n5", "\n"},
+		"diff -u refs.bib.orig refs.bib\n--- refs.bib.orig\n+++ refs.bib\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n",
+	},
+	{
+		[]string{"@misc{a}", "\n\n", "@misc{b}", "\n"},
+		[]string{"@misc{b}", "\n\n", "@misc{a}", "\n"},
+		"diff -u refs.bib.orig refs.bib\n--- refs.bib.orig\n+++ refs.bib\n@@ -1,3 +1,3 @@\n-@misc{a}\n+@misc{b}\n \n-@misc{b}\n+@misc{a}\n",
+	},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tt := range unifieddifftests {
+		if out := unifiedDiff("refs.bib", tt.a, tt.b); out != tt.out {
+			t.Errorf("unifiedDiff(%q, %q) => %q, want %q", tt.a, tt.b, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/iomz/bibfuse"
+	"github.com/nickng/bibtex"
+)
+
+// importBatchSize is the number of entries stored in a transaction
+const importBatchSize = 1000
+
+// importStats counts the entries by the result of importing
+type importStats struct {
+	added      int
+	updated    int
+	invalid    int
+	duplicates int
+	files      []*fileReport
+	rolledBack bool
+	dryRun     bool
+}
+
+// importReport is the result of an import for -format json
+type importReport struct {
+	Added      int           `json:"added"`
+	Updated    int           `json:"updated"`
+	Duplicates int           `json:"duplicates"`
+	Invalid    int           `json:"invalid"`
+	Errors     []string      `json:"errors"`
+	RolledBack bool          `json:"rolled_back"` // nothing is stored as a file or an entry failed without -partial
+	DryRun     bool          `json:"dry_run"`     // nothing is stored with -dry-run
+	Files      []*fileReport `json:"files"`
+}
+
+// fileReport is the result of importing a file
+type fileReport struct {
+	File       string         `json:"file"`
+	Added      int            `json:"added"`
+	Updated    int            `json:"updated"`
+	Duplicates []string       `json:"duplicates"`
+	Skipped    []skippedEntry `json:"skipped"`
+	Fixes      []nameFix      `json:"fixes"`
+	Warnings   []string       `json:"warnings"`
+	Errors     []string       `json:"errors"`
+}
+
+// skippedEntry is an entry failing to parse or build with the reason
+type skippedEntry struct {
+	Key    string `json:"key,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// nameFix is a name fixed in an author or editor field with -fix-authors
+type nameFix struct {
+	Key    string `json:"key"`
+	Line   int    `json:"line,omitempty"`
+	Field  string `json:"field"`
+	Kind   string `json:"kind"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+func (f nameFix) String() string {
+	return fmt.Sprintf("%s fixed (%s): %q -> %q", f.Field, f.Kind, f.Before, f.After)
+}
+
+// newFileReport returns the report of the file with the empty lists for JSON
+func newFileReport(file string) *fileReport {
+	return &fileReport{File: file, Duplicates: []string{}, Skipped: []skippedEntry{}, Fixes: []nameFix{}, Warnings: []string{}, Errors: []string{}}
+}
+
+// report returns the stats with the errors of the import
+func (s importStats) report(err error) importReport {
+	report := importReport{
+		Added:      s.added,
+		Updated:    s.updated,
+		Duplicates: s.duplicates,
+		Invalid:    s.invalid,
+		Errors:     []string{},
+		RolledBack: s.rolledBack,
+		DryRun:     s.dryRun,
+		Files:      s.files,
+	}
+	if report.Files == nil {
+		report.Files = []*fileReport{}
+	}
+	var failures importErrors
+	switch {
+	case errors.As(err, &failures):
+		for _, failure := range failures {
+			report.Errors = append(report.Errors, failure.Error())
+		}
+	case err != nil:
+		report.Errors = append(report.Errors, err.Error())
+	}
+	return report
+}
+
+// entryText returns the text of the entry block at the line in the file, or an empty string
+func entryText(parsed parsedFile, line int) string {
+	for _, block := range bibfuse.SplitBlocks(parsed.data) {
+		if block.Line == line {
+			return strings.TrimSpace(block.Text)
+		}
+	}
+	return ""
+}
+
+// skippedEntryOf returns the entry in the file skipped by err
+func skippedEntryOf(parsed parsedFile, err error) skippedEntry {
+	var parseErr *bibfuse.ParseError
+	var buildErr *bibfuse.BuildError
+	switch {
+	case errors.As(err, &parseErr):
+		skipped := skippedEntry{Line: parseErr.Line, Reason: parseErr.Err.Error()}
+		for citeName, line := range parsed.lines {
+			if line == parseErr.Line {
+				skipped.Key = citeName
+			}
+		}
+		return skipped
+	case errors.As(err, &buildErr):
+		return skippedEntry{Key: buildErr.CiteName, Line: parsed.lines[buildErr.CiteName], Reason: buildErr.Err.Error()}
+	}
+	return skippedEntry{Reason: err.Error()}
+}
+
+// logProblem logs the problem of an entry in the file at the level
+func logProblem(level string, parsed parsedFile, err error) {
+	problem := skippedEntryOf(parsed, err)
+	logEntry(level, parsed.path, problem.Line, problem.Key, problem.Reason)
+}
+
+// importError is a failure to import a file or an entry in it
+type importError struct {
+	file     string
+	citeName string
+	err      error
+}
+
+func (e *importError) Error() string {
+	if e.citeName == "" {
+		return fmt.Sprintf("%s: %v", e.file, e.err)
+	}
+	return fmt.Sprintf("%s: [%s] %v", e.file, e.citeName, e.err)
+}
+
+// importErrors are all the failures in an import
+type importErrors []*importError
+
+func (es importErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = "\t" + e.Error()
+	}
+	return fmt.Sprintf("%d failures in import:\n%s", len(es), strings.Join(msgs, "\n"))
+}
+
+// parsedFile holds the entries built from a .bib file
+type parsedFile struct {
+	path    string
+	data    []byte
+	bib     *bibtex.BibTex
+	items   []bibfuse.BibItem
+	invalid []error             // the entries skipped by parsing or building
+	warns   []error             // the problems of the entries imported
+	fixes   []nameFix           // the names fixed with -fix-authors
+	venues  []venueChange       // the venues normalized by the registry
+	tags    map[string][]string // the keywords of the entries by the cite name
+	lines   map[string]int      // the lines of the entries by the cite name
+	err     error
+}
+
+// venueChange is a field of an entry normalized by the venue registry to the value
+type venueChange struct {
+	key, field, value string
+}
+
+// importBibFiles stores the entries in the files in a transaction, nothing is stored
+// if any file or entry fails unless opts.partial
+func importBibFiles(db *sql.DB, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options, files []string) (importStats, error) {
+	stats := importStats{}
+	defined, err := loadStrings(db)
+	if err != nil {
+		return stats, err
+	}
+
+	w, err := newEntryWriter(db)
+	if err != nil {
+		return stats, err
+	}
+	parsedFiles := parseBibFiles(w, defined, filters, oneofs, opts, files)
+	batched := 0
+	var failures importErrors
+	var rejects []rejectedEntry
+	var parsedPaths []string // the files whose rejected entries are replaced
+
+	// store the results in the order of the files regardless of which was parsed first
+	for _, parsed := range parsedFiles {
+		log.Printf("parsing %s", parsed.path)
+		report := newFileReport(parsed.path)
+		stats.files = append(stats.files, report)
+		fail := func(citeName string, err error) {
+			failure := &importError{file: parsed.path, citeName: citeName, err: err}
+			failures = append(failures, failure)
+			report.Errors = append(report.Errors, failure.Error())
+		}
+		if parsed.err != nil {
+			fail("", parsed.err)
+			continue
+		}
+		parsedPaths = append(parsedPaths, parsed.path)
+		for _, err := range parsed.invalid {
+			stats.invalid++
+			skipped := skippedEntryOf(parsed, err)
+			logEntry(levelWarn, parsed.path, skipped.Line, skipped.Key, skipped.Reason)
+			report.Skipped = append(report.Skipped, skipped)
+			rejects = append(rejects, rejectedEntry{
+				Key:    skipped.Key,
+				File:   parsed.path,
+				Line:   skipped.Line,
+				Text:   entryText(parsed, skipped.Line),
+				Reason: skipped.Reason,
+			})
+			if opts.strict {
+				fail(skipped.Key, fmt.Errorf("rejected: %s", skipped.Reason))
+			}
+		}
+		for _, fix := range parsed.fixes {
+			logEntry(levelWarn, parsed.path, fix.Line, fix.Key, fix.String())
+			report.Fixes = append(report.Fixes, fix)
+		}
+		if opts.verbose {
+			for _, change := range parsed.venues {
+				logEntry(levelInfo, parsed.path, parsed.lines[change.key], change.key, fmt.Sprintf("%s normalized to %q", change.field, change.value))
+			}
+		}
+		for _, err := range parsed.warns {
+			logProblem(levelWarn, parsed, err)
+			report.Warnings = append(report.Warnings, err.Error())
+		}
+		for name, value := range parsed.bib.StringVar {
+			if err := w.storeString(name, value.String(), parsed.path, opts.update); err != nil {
+				fail(name, err)
+			}
+		}
+		if err := w.storeBlocks(parsed.path, bibfuse.SplitBlocks(parsed.data), opts.update); err != nil {
+			fail("", err)
+		}
+
+		for _, bi := range parsed.items {
+			var status entryStatus
+			if opts.update {
+				status, err = w.upsertEntry(bi)
+			} else {
+				status, err = w.insertEntry(bi)
+			}
+			if err != nil {
+				fail(bi.CiteName, err)
+				continue
+			}
+			tags := append(append([]string(nil), opts.tags...), parsed.tags[bi.CiteName]...)
+			if err := w.addTags(bi.CiteName, tags); err != nil {
+				fail(bi.CiteName, err)
+				continue
+			}
+			if err := w.trackSource(bi, parsed.path, parsed.lines[bi.CiteName], opts.update); err != nil {
+				fail(bi.CiteName, err)
+				continue
+			}
+
+			switch status {
+			case entryAdded:
+				stats.added++
+				report.Added++
+				if opts.verbose {
+					logEntry(levelInfo, parsed.path, parsed.lines[bi.CiteName], bi.CiteName, "added")
+				}
+			case entryUpdated:
+				stats.updated++
+				report.Updated++
+				if opts.verbose {
+					logEntry(levelInfo, parsed.path, parsed.lines[bi.CiteName], bi.CiteName, "updated")
+				}
+			default:
+				stats.duplicates++
+				report.Duplicates = append(report.Duplicates, bi.CiteName)
+				if opts.verbose {
+					logEntry(levelInfo, parsed.path, parsed.lines[bi.CiteName], bi.CiteName, "duplicate entry")
+				}
+			}
+
+			// a partial import doesn't need to hold everything in a transaction
+			if !opts.partial || opts.dryRun {
+				continue
+			}
+			if batched++; batched == importBatchSize {
+				if err := w.commit(); err != nil {
+					return stats, err
+				}
+				if w, err = newEntryWriter(db); err != nil {
+					return stats, err
+				}
+				batched = 0
+			}
+		}
+	}
+
+	if opts.dryRun {
+		if err := w.rollback(); err != nil {
+			return importStats{}, err
+		}
+		stats.dryRun = true
+		if len(failures) > 0 {
+			return stats, failures
+		}
+		return stats, nil
+	}
+	if len(failures) > 0 && !opts.partial {
+		if err := w.rollback(); err != nil {
+			return importStats{}, err
+		}
+		log.Printf("rolled back, no entries imported")
+		for _, report := range stats.files {
+			report.Added, report.Updated = 0, 0
+		}
+		stats = importStats{invalid: stats.invalid, files: stats.files, rolledBack: true}
+	} else if err := w.commit(); err != nil {
+		return stats, err
+	}
+
+	// the entries rejected are kept for bibfuse rejected whether the import is rolled back or not
+	if err := storeRejected(db, parsedPaths, rejects); err != nil {
+		return stats, err
+	}
+	if len(rejects) > 0 {
+		log.Printf("%d entries rejected, see bibfuse rejected", len(rejects))
+	}
+	if len(failures) > 0 {
+		return stats, failures
+	}
+	return stats, nil
+}
+
+// parseBibFiles parses the files and builds the entries with opts.jobs workers, of which
+// only one at a time runs bibtex.Parse; the results are in the same order as the files
+func parseBibFiles(w *entryWriter, defined map[string]string, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options, files []string) []parsedFile {
+	parsedFiles := make([]parsedFile, len(files))
+	forEachFile(opts.jobs, len(files), func(index int) {
+		parsedFiles[index] = readBibFile(files[index])
+	})
+
+	// the string variables can be used in the files other than the one defining them
+	values := collectStrings(parsedFiles, defined)
+	forEachFile(opts.jobs, len(files), func(index int) {
+		parseBibFile(&parsedFiles[index], values, opts)
+	})
+
+	// the parents can be in the other files or in the database
+	resolveCrossrefs(parsedFiles, w)
+	forEachFile(opts.jobs, len(files), func(index int) {
+		buildBibItems(&parsedFiles[index], filters, oneofs, opts)
+	})
+	return parsedFiles
+}
+
+// forEachFile calls f with the indices from 0 to n-1 in jobs goroutines
+func forEachFile(jobs, n int, f func(index int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				f(index)
+			}
+		}()
+	}
+	for index := 0; index < n; index++ {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+}
+
+func readBibFile(fileName string) parsedFile {
+	parsed := parsedFile{path: filepath.Join(".", fileName)}
+	parsed.data, parsed.err = os.ReadFile(parsed.path)
+	return parsed
+}
+
+// collectStrings returns the string variables defined in the files and the ones already defined
+func collectStrings(parsedFiles []parsedFile, defined map[string]string) map[string]string {
+	values := make(map[string]string, len(defined))
+	for name, value := range defined {
+		values[name] = value
+	}
+	for _, parsed := range parsedFiles {
+		if parsed.err != nil {
+			continue
+		}
+		var sb strings.Builder
+		for _, block := range bibfuse.SplitBlocks(parsed.data) {
+			if block.Type == "string" {
+				sb.WriteString(block.Text + "\n")
+			}
+		}
+		// the malformed definitions are reported when parsing the file
+		bib, _ := bibfuse.ParseTolerantWithStrings([]byte(sb.String()), parsed.path, values)
+		for name, value := range bib.StringVar {
+			values[name] = value.String()
+		}
+	}
+	return values
+}
+
+func parseBibFile(parsed *parsedFile, values map[string]string, opts options) {
+	if parsed.err != nil {
+		return
+	}
+	parsed.lines = make(map[string]int)
+	for _, block := range bibfuse.SplitBlocks(parsed.data) {
+		if _, ok := parsed.lines[block.Key()]; block.IsEntry() && !ok {
+			parsed.lines[block.Key()] = block.Line
+		}
+	}
+	if opts.tolerant {
+		var parseErrs []*bibfuse.ParseError
+		parsed.bib, parseErrs = bibfuse.ParseTolerantWithStrings(parsed.data, parsed.path, values)
+		for _, err := range parseErrs {
+			parsed.invalid = append(parsed.invalid, err)
+		}
+		return
+	}
+	parsed.bib, parsed.err = bibfuse.ParseWithStrings(bytes.NewReader(parsed.data), values)
+}
+
+// resolveCrossrefs copies the fields of the crossref and xdata parents to the entries,
+// the first entry imported with a cite name is the parent, then the one in the database if w isn't nil
+func resolveCrossrefs(parsedFiles []parsedFile, w *entryWriter) {
+	entries := make(map[string]*bibtex.BibEntry)
+	for _, parsed := range parsedFiles {
+		if parsed.err != nil {
+			continue
+		}
+		for _, entry := range parsed.bib.Entries {
+			if _, ok := entries[entry.CiteName]; !ok {
+				entries[entry.CiteName] = entry
+			}
+		}
+	}
+	lookup := func(citeName string) (*bibtex.BibEntry, bool) {
+		if entry, ok := entries[citeName]; ok {
+			return entry, true
+		}
+		if w == nil {
+			return nil, false
+		}
+		return w.findEntry(citeName)
+	}
+
+	for _, parsed := range parsedFiles {
+		if parsed.err != nil {
+			continue
+		}
+		for _, entry := range parsed.bib.Entries {
+			// the entry is still imported with the fields it has
+			if err := bibfuse.InheritCrossref(entry, lookup); err != nil {
+				logProblem(levelWarn, parsed, err)
+			}
+		}
+	}
+}
+
+func buildBibItems(parsed *parsedFile, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options) {
+	if parsed.err != nil {
+		return
+	}
+	for _, entry := range parsed.bib.Entries {
+		if !opts.expandStrings {
+			bibfuse.CollapseStringVars(entry)
+		}
+		// the accents are stored in Unicode whichever way they are written
+		bibfuse.ConvertEntryFields(entry, bibfuse.LaTeXToUnicode)
+		if keywords, ok := entry.Fields["keywords"]; ok && opts.keywordTags {
+			if parsed.tags == nil {
+				parsed.tags = make(map[string][]string)
+			}
+			parsed.tags[entry.CiteName] = bibfuse.SplitKeywords(keywords.String())
+		}
+		if opts.fixAuthors {
+			fixAuthorFields(parsed, entry)
+		}
+		bi, err := filters.BuildBibItem(entry, opts.smart, oneofs)
+		if err != nil {
+			parsed.invalid = append(parsed.invalid, err)
+			continue
+		}
+		parsed.warns = append(parsed.warns, bibfuse.CheckTitles(bi)...)
+		for _, fieldName := range opts.venues.Normalize(&bi) {
+			value, _ := bi.FieldValueByBibTexName(fieldName)
+			parsed.venues = append(parsed.venues, venueChange{bi.CiteName, fieldName, value})
+		}
+		opts.titleWords.FormatTitles(&bi, opts.protectTitles, opts.titleCase)
+		parsed.items = append(parsed.items, bi)
+	}
+}
+
+// fixAuthorFields fixes the names in the author and editor fields of the entry
+func fixAuthorFields(parsed *parsedFile, entry *bibtex.BibEntry) {
+	for _, field := range []string{"author", "editor"} {
+		value, ok := entry.Fields[field]
+		if !ok {
+			continue
+		}
+		fixed, fixes := bibfuse.FixAuthors(value.String())
+		if len(fixes) == 0 {
+			continue
+		}
+		entry.Fields[field] = bibtex.NewBibConst(fixed)
+		for _, fix := range fixes {
+			parsed.fixes = append(parsed.fixes, nameFix{
+				Key:    entry.CiteName,
+				Line:   parsed.lines[entry.CiteName],
+				Field:  field,
+				Kind:   fix.Kind,
+				Before: fix.Before,
+				After:  fix.After,
+			})
+		}
+	}
+}
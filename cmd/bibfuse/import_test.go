@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+// countRows returns the number of the rows in the table
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestImportDryRun(t *testing.T) {
+	path := writeTestFile(t, `@string{acm = "ACM"}
+@preamble{"\noop"}
+@article{smith2020,
+  author = {J Smith},
+  title = {A},
+  journal = {J},
+  year = 2020,
+}
+@article{roe2020,
+  author = {Roe, J},
+  title = {B},
+  journal = acm,
+  year = 2020,
+  keywords = {rfid},
+}
+@misc{broken,
+  title = {Broken
+`)
+	db := openTestDB(t)
+	config := bibfuse.DefaultConfig()
+	filters, oneofs := config.Filters(), config.Oneofs()
+	opts := options{tolerant: true, fixAuthors: true, keywordTags: true, dryRun: true}
+
+	// the dry run reports the import and the fixes without writing anything
+	stats, err := importBibFiles(db, filters, oneofs, opts, []string{path})
+	if err != nil {
+		t.Fatalf("importBibFiles() err => %v, want nil", err)
+	}
+	if !stats.dryRun || stats.added != 2 || stats.invalid != 1 {
+		t.Errorf("importBibFiles() => %+v, want a dry run of 2 added and 1 invalid", stats)
+	}
+	if fixes := stats.files[0].Fixes; len(fixes) != 2 || fixes[0].After != "J. Smith" || fixes[1].After != "Roe, J." {
+		t.Errorf("importBibFiles() fixes => %v, want J. Smith and Roe, J.", fixes)
+	}
+	for _, table := range []string{"entries", "strings", "tags", "sources", "blocks", "rejected"} {
+		if count := countRows(t, db, table); count != 0 {
+			t.Errorf("importBibFiles() of a dry run wrote %d rows to %s, want 0", count, table)
+		}
+	}
+
+	// the same import without the dry run writes them
+	opts.dryRun = false
+	if stats, err = importBibFiles(db, filters, oneofs, opts, []string{path}); err != nil {
+		t.Fatalf("importBibFiles() err => %v, want nil", err)
+	}
+	if stats.dryRun || stats.added != 2 {
+		t.Errorf("importBibFiles() => %+v, want 2 added", stats)
+	}
+	for table, want := range map[string]int{"entries": 2, "strings": 1, "tags": 1, "sources": 2, "rejected": 1} {
+		if count := countRows(t, db, table); count != want {
+			t.Errorf("importBibFiles() wrote %d rows to %s, want %d", count, table, want)
+		}
+	}
+	bi, err := scanEntry(db.QueryRow(selectEntrySQL+" WHERE cite_name = ?", "smith2020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi.Author != "J. Smith" {
+		t.Errorf("the author of smith2020 => %v, want J. Smith", bi.Author)
+	}
+}
+
+const strictTestSource = `@article{smith2020,
+  author = {Smith, John},
+  title = {A},
+  journal = {J},
+  year = 2020,
+}
+@article{roe2021,
+  author = {Roe, Jane},
+  title = {B
+`
+
+func TestImportStrict(t *testing.T) {
+	path := writeTestFile(t, strictTestSource)
+	db := openTestDB(t)
+	config := bibfuse.DefaultConfig()
+	filters, oneofs := config.Filters(), config.Oneofs()
+
+	// the entry rejected fails the import, and nothing is imported
+	_, err := importBibFiles(db, filters, oneofs, options{tolerant: true, strict: true}, []string{path})
+	var failures importErrors
+	if !errors.As(err, &failures) || len(failures) != 1 {
+		t.Fatalf("importBibFiles() of a bad entry with -strict err => %v, want the entry rejected", err)
+	}
+	// but the entry rejected is kept for bibfuse rejected
+	for table, want := range map[string]int{"entries": 0, "sources": 0, "rejected": 1} {
+		if count := countRows(t, db, table); count != want {
+			t.Errorf("importBibFiles() failing with -strict wrote %d rows to %s, want %d", count, table, want)
+		}
+	}
+
+	// the same import without -strict imports the rest
+	if _, err := importBibFiles(db, filters, oneofs, options{tolerant: true}, []string{path}); err != nil {
+		t.Fatalf("importBibFiles() err => %v, want nil", err)
+	}
+	if entries, rejected := countRows(t, db, "entries"), countRows(t, db, "rejected"); entries != 1 || rejected != 1 {
+		t.Errorf("importBibFiles() wrote %d entries and %d rejected, want 1 and 1", entries, rejected)
+	}
+}
+
+// writeBatchTestFile writes the entries more than two batches in a file, and returns the path
+// and the number of the entries
+func writeBatchTestFile(t *testing.T) (string, int) {
+	t.Helper()
+	var sb strings.Builder
+	n := 2*importBatchSize + importBatchSize/2
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "@article{entry%d,\n  author = {Smith, John},\n  title = {Title %d},\n  journal = {J},\n  year = 2020,\n}\n", i, i)
+	}
+	return writeTestFile(t, sb.String()), n
+}
+
+// failTestEntry makes the entry fail to be stored in the database
+func failTestEntry(t *testing.T, db *sql.DB, citeName string) {
+	t.Helper()
+	if _, err := db.Exec(`CREATE TRIGGER fail_entry BEFORE INSERT ON entries WHEN NEW.cite_name = '` + citeName + `'
+	BEGIN SELECT RAISE(ABORT, 'the entry fails'); END`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportRollback(t *testing.T) {
+	path, n := writeBatchTestFile(t)
+	config := bibfuse.DefaultConfig()
+	filters, oneofs := config.Filters(), config.Oneofs()
+
+	// an entry failing in the last batch rolls back the whole import
+	db := openTestDB(t)
+	failTestEntry(t, db, fmt.Sprintf("entry%d", n-10))
+	stats, err := importBibFiles(db, filters, oneofs, options{}, []string{path})
+	var failures importErrors
+	if !errors.As(err, &failures) || len(failures) != 1 {
+		t.Fatalf("importBibFiles() err => %v, want the entry failing", err)
+	}
+	if !stats.rolledBack || stats.added != 0 {
+		t.Errorf("importBibFiles() => %+v, want rolled back", stats)
+	}
+	for _, table := range []string{"entries", "sources", "blocks"} {
+		if count := countRows(t, db, table); count != 0 {
+			t.Errorf("importBibFiles() rolled back wrote %d rows to %s, want 0", count, table)
+		}
+	}
+
+	// with -partial, the batches are committed but the entry failing
+	db = openTestDB(t)
+	failTestEntry(t, db, fmt.Sprintf("entry%d", n-10))
+	stats, err = importBibFiles(db, filters, oneofs, options{partial: true}, []string{path})
+	if !errors.As(err, &failures) || len(failures) != 1 {
+		t.Fatalf("importBibFiles() with -partial err => %v, want the entry failing", err)
+	}
+	if stats.rolledBack || stats.added != n-1 {
+		t.Errorf("importBibFiles() with -partial => %d added, rolled back %v, want %d added", stats.added, stats.rolledBack, n-1)
+	}
+	if count := countRows(t, db, "entries"); count != n-1 {
+		t.Errorf("importBibFiles() with -partial wrote %d entries, want %d", count, n-1)
+	}
+}
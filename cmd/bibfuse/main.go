@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -10,59 +11,36 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 
 	"github.com/iomz/bibfuse"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nickng/bibtex"
-	"github.com/spf13/viper"
 )
 
 const (
-	defaultConfigFile = "bibfuse.toml"
-	defaultDBFile     = "bib.db"
-	defaultOutFile    = "out.bib"
+	defaultConfigFile     = "bibfuse.toml"
+	defaultDBFile         = "bib.db"
+	defaultOutFile        = "out.bib"
+	defaultVenuesFile     = "venues.toml"
+	defaultTitleWordsFile = "title-words.txt"
 )
 
-var (
-	optionalLineRE = regexp.MustCompile("(?m)[\r\n]+^.*(OPTIONAL).*$")
-	todoLineRE     = regexp.MustCompile("(?m)[\r\n]+^.*(TODO).*$")
-	emptyLineRE    = regexp.MustCompile("(?m)[\r\n]+^.*\"\".*$")
+// the names written for -venue
+const (
+	venueFull   = "full"
+	venueAbbrev = "abbrev"
 )
 
+// the encodings of the resulting bibtex for -encoding
 const (
-	createTableSQL = `CREATE TABLE IF NOT EXISTS entries(
-            id INTEGER PRIMARY KEY,
-            cite_name TEXT UNIQUE NOT NULL,
-            cite_type TEXT NOT NULL,
-            author TEXT DEFAULT "",
-            title TEXT DEFAULT "",
-            booktitle TEXT DEFAULT "",
-            doi TEXT DEFAULT "",
-            edition TEXT DEFAULT "",
-            isbn TEXT DEFAULT "",
-            issn TEXT DEFAULT "",
-            institution TEXT DEFAULT "",
-            journal TEXT DEFAULT "",
-            metanote TEXT DEFAULT "",
-            note TEXT DEFAULT "",
-            number TEXT DEFAULT "",
-            numpages TEXT DEFAULT "",
-            pages TEXT DEFAULT "",
-            publisher TEXT DEFAULT "",
-            school TEXT DEFAULT "",
-            series TEXT DEFAULT "",
-            type TEXT DEFAULT "",
-            url TEXT DEFAULT "",
-            version TEXT DEFAULT "",
-            volume TEXT DEFAULT "",
-            year TEXT
-        );`
-	insertEntrySQL = `INSERT OR IGNORE INTO entries (
-            cite_name, cite_type, title, author, booktitle, doi, edition, isbn, issn,
-            institution, journal, metanote, note, number, numpages, pages, publisher,
-            school, series, url, type, version, volume, year
-        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	encodingUnicode = "unicode"
+	encodingLaTeX   = "latex"
+)
+
+var (
+	stringRefRE = regexp.MustCompile(`["{]#([^#"{}\s]+)#["}]`)
 )
 
 type options struct {
@@ -75,54 +53,159 @@ type options struct {
 	showEmpty        bool
 	smart            bool
 	verbose          bool
+	jobs             int
 	showVersion      bool
+	check            bool
+	partial          bool
+	tolerant         bool
+	expandStrings    bool
+	venuesFile       string
+	venue            string
+	encoding         string
+	indent           string               // the number of spaces or tab to indent the fields written
+	writeOptions     bibfuse.WriteOptions // the style of the bibtex written
+	tags             stringList           // the tags of the entries imported
+	tagFilter        stringList           // the tags of the entries exported
+	keywordTags      bool
+	selector         bibfuse.Selector // the entries exported
+	sortSpec         bibfuse.SortSpec // the order of the entries exported
+	venues           bibfuse.Venues   // the registry loaded from venuesFile
+	titleWordsFile   string
+	titleWords       bibfuse.TitleWords // the word list loaded from titleWordsFile
+	protectTitles    bool
+	titleCase        string
+	update           bool         // update the existing entries with the imported ones
+	strict           bool         // fail the import if any entry is rejected
+	fixAuthors       bool         // fix the initials, the reversed names, and et al. in the authors and editors
+	dryRun           bool         // report the import without storing anything
+	format           outputFormat // the format of the report on stdout
+}
+
+// commands are the subcommands taking the rest of the arguments
+var commands = map[string]func(args []string) error{
+	"config":       runConfig,
+	"diff":         runDiff,
+	"import":       runImport,
+	"export":       runExport,
+	"fmt":          runFmt,
+	"git":          runGit,
+	"merge-driver": runMergeDriver,
+	"rejected":     runRejected,
+	"search":       runSearch,
+	"sync":         runSync,
+	"tag":          runTag,
+	"watch":        runWatch,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
+
 	opts, files := parseFlags()
 	if opts.showVersion {
 		printVersion()
 		return
 	}
 	if err := run(opts, files); err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
 
 func parseFlags() (options, []string) {
 	opts := options{}
-
-	conf := flag.String("config", defaultConfigFile, "The bibfuse.[toml|yml] defining the filters.")
-	dbFile := flag.String("db", defaultDBFile, "The SQLite file to read/write.")
-	noOption := flag.Bool("no-optional", false, "Suppress \"OPTIONAL\" fields in the resulting bibtex.")
-	noTodo := flag.Bool("no-todo", false, "Suppress \"TODO\" fields in the resulting bibtex.")
-	outFile := flag.String("out", defaultOutFile, "The resulting bibtex to write (it overrides if exists).")
-	showEmpty := flag.Bool("show-empty", false, "Do not hide empty fields in the resulting bibtex.")
-	smart := flag.Bool("smart", false, "Use oneof selectively filters when importing bibtex.")
-	verbose := flag.Bool("verbose", false, "Print verbose messages.")
-	version := flag.Bool("version", false, "Print version.")
+	bindFlags(flag.CommandLine, &opts)
+	flag.BoolVar(&opts.check, "check", false, "Exit non-zero if the resulting bibtex is stale relative to the database, without writing it.")
+	flag.BoolVar(&opts.showVersion, "version", false, "Print version.")
+	bindReportFlags(flag.CommandLine, &opts.format)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s: [options] [.bib ... .bib]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config [options] show|init|check\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s diff [options] old.bib new.bib | -db old.db new.db\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s export [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s fmt [options] .bib ... .bib\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s git [options] install-driver\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s import [options] .bib ... .bib\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s merge-driver [options] base ours theirs\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s rejected [options] [ls|retry] [key ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s search [options] query\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s sync [options] [.bib ... .bib]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s tag [options] add|rm|ls [key] [tag ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s watch [options] .bib ... .bib\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
-
-	opts.config = *conf
-	opts.useDefaultConfig = *conf == defaultConfigFile
-	opts.dbFile = *dbFile
-	opts.outFile = *outFile
-	opts.noOptional = *noOption
-	opts.noTodo = *noTodo
-	opts.showEmpty = *showEmpty
-	opts.smart = *smart
-	opts.verbose = *verbose
-	opts.showVersion = *version
+	opts.useDefaultConfig = opts.config == defaultConfigFile
 
 	return opts, flag.Args()
 }
 
+// bindFlags defines the options to import and export bibtex on fs
+func bindFlags(fs *flag.FlagSet, opts *options) {
+	bindImportFlags(fs, opts)
+	bindOutputFlags(fs, opts)
+	fs.StringVar(&opts.outFile, "out", defaultOutFile, "The resulting bibtex to write (it overrides if exists).")
+}
+
+// bindOutputFlags defines the options to write the resulting bibtex on fs
+func bindOutputFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.noOptional, "no-optional", false, "Suppress \"OPTIONAL\" fields in the resulting bibtex.")
+	fs.BoolVar(&opts.noTodo, "no-todo", false, "Suppress \"TODO\" fields in the resulting bibtex.")
+	fs.BoolVar(&opts.showEmpty, "show-empty", false, "Do not hide empty fields in the resulting bibtex.")
+	fs.StringVar(&opts.encoding, "encoding", encodingUnicode, "Write the accents in Unicode for biber or in LaTeX for the legacy BibTeX (unicode|latex).")
+	fs.StringVar(&opts.venue, "venue", venueFull, "Write the journal and booktitle with the full names or the abbreviations in the venue registry (full|abbrev).")
+	bindWriteFlags(fs, opts)
+}
+
+// bindWriteFlags defines the style of the bibtex written on fs
+func bindWriteFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.writeOptions.Delimiter, "delimiter", bibfuse.DelimiterQuotes, "Delimit the values with quotes or braces, the values with quotes or braces in them are braced anyway (quotes|braces).")
+	fs.StringVar(&opts.indent, "indent", "4", "Indent the fields with the number of spaces or a tab (N|tab).")
+	fs.BoolVar(&opts.writeOptions.Align, "align", true, "Align the = of the fields in an entry.")
+	fs.BoolVar(&opts.writeOptions.TrailingComma, "trailing-comma", true, "Put a comma after the last field of an entry.")
+	fs.StringVar(&opts.writeOptions.KeyCase, "key-case", bibfuse.KeyCaseLower, "Write the entry types and the field names in lowercase or uppercase (lower|upper).")
+	fs.IntVar(&opts.writeOptions.Wrap, "wrap", 0, "Wrap the long values at the spaces to fit the lines in the columns, 0 not to wrap.")
+}
+
+// bindImportFlags defines the options to import bibtex on fs
+func bindImportFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.config, "config", defaultConfigFile, "The bibfuse.[toml|yml] defining the filters.")
+	fs.StringVar(&opts.dbFile, "db", defaultDBFile, "The SQLite file to read/write.")
+	fs.BoolVar(&opts.smart, "smart", false, "Use oneof selectively filters when importing bibtex.")
+	fs.BoolVar(&opts.verbose, "verbose", false, "Print verbose messages.")
+	fs.IntVar(&opts.jobs, "jobs", runtime.NumCPU(), "The number of .bib files to read, split into entries, and build the entries of concurrently; the bibtex parser runs on a file at a time.")
+	fs.BoolVar(&opts.partial, "partial", false, "Keep the entries imported successfully even if some files or entries fail.")
+	fs.BoolVar(&opts.tolerant, "tolerant", false, "Parse the entries one by one, and skip and report the malformed ones.")
+	fs.BoolVar(&opts.fixAuthors, "fix-authors", false, "Fix the missing dots and spaces in the initials, the reversed names, and et al. in the authors and editors, and log each fix.")
+	fs.BoolVar(&opts.strict, "strict", false, "Fail the import if any entry is rejected, nothing is imported unless -partial.")
+	fs.StringVar(&opts.venuesFile, "venues", defaultVenuesFile, "The venue registry normalizing the journal and booktitle.")
+	fs.StringVar(&opts.titleWordsFile, "title-words", defaultTitleWordsFile, "The proper nouns and acronyms keeping their case in titles, a word per line.")
+	fs.BoolVar(&opts.protectTitles, "protect-titles", false, "Brace-protect the acronyms and the listed words in the titles.")
+	fs.StringVar(&opts.titleCase, "title-case", bibfuse.TitleCaseKeep, "Convert the titles to sentence or title case (sentence|title).")
+	fs.BoolVar(&opts.expandStrings, "expand-strings", true, "Expand the @string variables in the entries, or keep referring to them.")
+	fs.Var(&opts.tags, "tag", "Tag the entries imported (repeatable).")
+	fs.BoolVar(&opts.keywordTags, "keywords", false, "Tag the entries with their keywords on import, and write the tags as the keywords.")
+}
+
+// stringList is a flag.Value collecting the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func printVersion() {
 	bi, _ := debug.ReadBuildInfo()
 	fmt.Printf("%v\n", bi.Main.Version)
@@ -137,6 +220,13 @@ func run(opts options, files []string) error {
 	if err != nil {
 		return err
 	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+
+	if opts.check && len(files) > 0 {
+		return fmt.Errorf("-check compares %s with the database, it takes no .bib files", opts.outFile)
+	}
 
 	dbPath := filepath.Join(".", opts.dbFile)
 	db, err := createDB(dbPath)
@@ -145,232 +235,376 @@ func run(opts options, files []string) error {
 	}
 	defer db.Close()
 
-	newItemCount, err := importBibFiles(db, filters, oneofs, opts, files)
-	if err != nil {
-		return err
+	stats, importErr := importBibFiles(db, filters, oneofs, opts, files)
+	report := runReport{Import: stats.report(importErr)}
+	report.Out = filepath.Join(".", opts.outFile)
+	if importErr != nil && !opts.partial {
+		return report.print(opts.format, importErr)
 	}
-	log.Printf("+%d new entries", newItemCount)
+	log.Printf("+%d new entries", stats.added)
 
 	content, entryCount, err := exportBibliography(db, opts)
 	if err != nil {
 		return err
 	}
 	log.Printf("%s contains %d entries", dbPath, entryCount)
+	report.Entries = entryCount
 
-	outPath := filepath.Join(".", opts.outFile)
-	if err := os.WriteFile(outPath, []byte(content), 0o644); err != nil {
+	if opts.check {
+		checkErr := checkOutput(report.Out, []byte(content))
+		report.Stale = checkErr != nil
+		return report.print(opts.format, checkErr)
+	}
+	if report.Written, err = writeOutput(report.Out, []byte(content)); err != nil {
 		return err
 	}
-	log.Printf("%d entries written to %s", entryCount, outPath)
+	if report.Written {
+		log.Printf("%d entries written to %s", entryCount, report.Out)
+	} else {
+		log.Printf("%s is up to date", report.Out)
+	}
 
-	return nil
+	// with -partial, the failures are reported after writing the entries imported
+	return report.print(opts.format, importErr)
 }
 
-func configureViper(opts options) error {
-	if !opts.useDefaultConfig {
-		configPath, err := filepath.Abs(opts.config)
-		if err != nil {
-			return err
-		}
-		viper.SetConfigFile(configPath)
-		return nil
-	}
+// exportReport is the result of writing the resulting bibtex
+type exportReport struct {
+	Entries int    `json:"entries"`
+	Out     string `json:"out"`
+	Written bool   `json:"written"`
+}
 
-	viper.SetConfigName("bibfuse")
-	viper.AddConfigPath(".")
+// runReport is the result of importing the files and writing the resulting bibtex
+type runReport struct {
+	Import importReport `json:"import"`
+	exportReport
+	Stale bool `json:"stale,omitempty"` // with -check
+}
 
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		return fmt.Errorf("no caller information")
+// print writes the report in JSON if format says so, and returns err
+func (r runReport) print(format outputFormat, err error) error {
+	if !format.isJSON() {
+		return err
 	}
-	viper.AddConfigPath(filepath.Join(filepath.Dir(filename), "../../"))
-	return nil
+	if printErr := printJSON(r); printErr != nil {
+		return printErr
+	}
+	return err
 }
 
-func loadRules() (bibfuse.Filters, bibfuse.Oneofs, error) {
-	if err := viper.ReadInConfig(); err != nil {
-		return nil, nil, fmt.Errorf("config: %w", err)
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	opts := options{}
+	bindImportFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Report the import, e.g., the fixes with -fix-authors, without writing the database.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s import: [options] .bib ... .bib\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
+	opts.useDefaultConfig = opts.config == defaultConfigFile
 
-	filters := make(bibfuse.Filters)
-	oneofs := make(bibfuse.Oneofs)
+	if err := configureViper(opts); err != nil {
+		return err
+	}
+	filters, oneofs, err := loadRules()
+	if err != nil {
+		return err
+	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
 
-	for _, key := range viper.AllKeys() {
-		keys := strings.Split(key, ".")
-		if len(keys) < 2 {
-			continue
-		}
-		citeType, filterType := keys[0], keys[1]
-		switch {
-		case filterType == "todos" || filterType == "optionals":
-			if !filters.HasFilter(citeType) {
-				filters[citeType] = bibfuse.NewFilter()
-			}
-			filters[citeType][filterType] = viper.GetStringSlice(key)
-		case strings.HasPrefix(filterType, "oneof_"):
-			if !oneofs.HasOneof(citeType) {
-				oneofs[citeType] = bibfuse.NewOneof()
-			}
-			oneofs[citeType].AddOneof(viper.GetStringSlice(key))
+	db, err := createDB(filepath.Join(".", opts.dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	stats, err := importBibFiles(db, filters, oneofs, opts, fs.Args())
+	if opts.format.isJSON() {
+		if printErr := printJSON(stats.report(err)); printErr != nil {
+			return printErr
 		}
 	}
-	return filters, oneofs, nil
+	if err != nil && !opts.partial {
+		return err
+	}
+	if opts.dryRun {
+		log.Printf("+%d new entries (dry run, nothing imported)", stats.added)
+		return err
+	}
+	log.Printf("+%d new entries", stats.added)
+	return err
 }
 
-func createDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// runExport writes the entries in the database to the out file without importing
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	opts := options{titleWordsFile: defaultTitleWordsFile}
+	fs.StringVar(&opts.dbFile, "db", defaultDBFile, "The SQLite file to read/write.")
+	fs.StringVar(&opts.outFile, "out", defaultOutFile, "The resulting bibtex to write (it overrides if exists).")
+	fs.StringVar(&opts.venuesFile, "venues", defaultVenuesFile, "The venue registry normalizing the journal and booktitle.")
+	bindOutputFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	fs.Var(&opts.tagFilter, "tag", "Export the entries with the tag, or without it if prefixed with ! (repeatable).")
+	fs.BoolVar(&opts.keywordTags, "keywords", false, "Write the tags as the keywords.")
+	where := fs.String("where", "", "Export the entries matching the filter expression, e.g., 'type:article year:2018.. has:doi'.")
+	sortSpec := fs.String("sort", "key", "Sort the entries by the keys (author, key, title, type, or year, followed by asc or desc), e.g., 'year desc, key'.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s export: [options]\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "The terms of -where are all required, and negated if prefixed with !:")
+		fmt.Fprintln(os.Stderr, "  type:article,inproceedings  year:2018..  has:doi  todo:author  todo:*  optional:doi  tag:thesis  title~(?i)rfid")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var err error
+	if opts.selector, err = bibfuse.ParseSelector(*where); err != nil {
+		return err
+	}
+	if opts.sortSpec, err = bibfuse.ParseSortSpec(*sortSpec); err != nil {
+		return err
+	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+
+	db, err := createDB(filepath.Join(".", opts.dbFile))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("table creation failed: %w", err)
 	}
-	if _, err := db.Exec(createTableSQL); err != nil {
-		db.Close()
-		return nil, err
+	defer db.Close()
+	report, err := writeBibliography(db, opts)
+	if err != nil {
+		return err
+	}
+	if opts.format.isJSON() {
+		return printJSON(report)
 	}
-	return db, nil
+	return nil
 }
 
-func importBibFiles(db *sql.DB, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options, files []string) (int, error) {
-	newItemCount := 0
-	for _, fileName := range files {
-		filePath := filepath.Join(".", fileName)
-		log.Printf("parsing %s", filePath)
-
-		reader, err := os.Open(filePath)
+func exportBibliography(db *sql.DB, opts options) (string, int, error) {
+	items, err := queryEntries(db, selectEntrySQL+" ORDER BY cite_name ASC")
+	if err != nil {
+		return "", 0, err
+	}
+	if filter := bibfuse.NewTagFilter(opts.tagFilter); !filter.IsEmpty() || len(opts.selector) > 0 {
+		tags, err := loadTags(db)
 		if err != nil {
-			return newItemCount, err
+			return "", 0, err
 		}
-
-		parsed, err := bibtex.Parse(reader)
-		reader.Close()
-		if err != nil {
-			return newItemCount, err
+		selected := items[:0]
+		for _, bi := range items {
+			if filter.Match(tags[bi.CiteName]) && opts.selector.Match(bi, tags[bi.CiteName]) {
+				selected = append(selected, bi)
+			}
 		}
+		items = selected
+	}
+	opts.sortSpec.Sort(items)
+	content, err := formatBibliography(db, items, opts)
+	if err != nil {
+		return "", 0, err
+	}
 
-		for _, entry := range parsed.Entries {
-			bi, err := filters.BuildBibItem(entry, opts.smart, oneofs)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+	// the documents may depend on the preambles of the files imported
+	preambles, err := loadPreambles(db)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(preambles) > 0 {
+		content = strings.Join(preambles, "\n") + "\n\n" + content
+	}
+	return content, len(items), nil
+}
 
-			added, err := insertEntry(db, bi)
-			if err != nil {
-				return newItemCount, fmt.Errorf("[%s] %w", entry.CiteName, err)
-			}
+// queryEntries returns the entries selected by query, selectEntrySQL with the conditions
+func queryEntries(db *sql.DB, query string, args ...interface{}) ([]bibfuse.BibItem, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-			if added {
-				newItemCount++
-				if opts.verbose {
-					log.Printf("added %s", entry.CiteName)
-				}
-			} else if opts.verbose {
-				log.Printf("[%s] duplicate entry", entry.CiteName)
-			}
+	var items []bibfuse.BibItem
+	for rows.Next() {
+		bi, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
 		}
+		items = append(items, bi)
 	}
-	return newItemCount, nil
+	return items, rows.Err()
 }
 
-func insertEntry(db *sql.DB, bi bibfuse.BibItem) (bool, error) {
-	res, err := db.Exec(
-		insertEntrySQL,
-		bi.CiteName,
-		bi.CiteType,
-		bi.Title,
-		bi.Author,
-		bi.Booktitle,
-		bi.DOI,
-		bi.Edition,
-		bi.ISBN,
-		bi.ISSN,
-		bi.Institution,
-		bi.Journal,
-		bi.Metanote,
-		bi.Note,
-		bi.Number,
-		bi.Numpages,
-		bi.Pages,
-		bi.Publisher,
-		bi.School,
-		bi.Series,
-		bi.URL,
-		bi.TechreportType,
-		bi.Version,
-		bi.Volume,
-		bi.Year,
-	)
-	if err != nil {
-		return false, err
+// formatBibliography returns the resulting bibtex of the entries with the output options
+func formatBibliography(db *sql.DB, items []bibfuse.BibItem, opts options) (string, error) {
+	var tags map[string][]string
+	if opts.keywordTags {
+		var err error
+		if tags, err = loadTags(db); err != nil {
+			return "", err
+		}
 	}
-	affected, err := res.RowsAffected()
+
+	var sb strings.Builder
+	w := bibfuse.NewWriter(&sb, opts.writeOptions)
+	for _, bi := range items {
+		if opts.venue == venueAbbrev {
+			opts.venues.Abbreviate(&bi)
+		}
+		if opts.encoding == encodingLaTeX {
+			bibfuse.ConvertFields(&bi, bibfuse.UnicodeToLaTeX)
+		}
+		entry := bi.ToBibEntry()
+		hideFields(entry, opts)
+		cleanBackslashes(entry)
+		if len(tags[bi.CiteName]) > 0 {
+			entry.AddField("keywords", bibtex.NewBibConst(bibfuse.JoinKeywords(tags[bi.CiteName])))
+		}
+		if err := w.WriteEntry(entry); err != nil {
+			return "", err
+		}
+	}
+
+	outString, err := referStrings(db, sb.String())
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	return affected > 0, nil
+	return outString, nil
 }
 
-func exportBibliography(db *sql.DB, opts options) (string, int, error) {
-	rows, err := db.Query(`SELECT cite_name, cite_type, title, author, booktitle, doi, edition, isbn, issn, institution, journal, metanote, note, number, numpages, pages, publisher, school, series, type, url, version, volume, year FROM entries ORDER BY cite_name ASC`)
+// referStrings replaces the values imported without -expand-strings (i.e., `#name#` delimited)
+// with the string variables and prepends the definitions of the ones used
+func referStrings(db *sql.DB, input string) (string, error) {
+	if !stringRefRE.MatchString(input) {
+		return input, nil
+	}
+	// without a database, the definitions are where the entries are written (e.g., by fmt)
+	if db == nil {
+		return stringRefRE.ReplaceAllString(input, "$1"), nil
+	}
+	values, err := loadStrings(db)
 	if err != nil {
-		return "", 0, err
+		return "", err
 	}
-	defer rows.Close()
 
-	bib := bibtex.NewBibTex()
+	used := make(map[string]bool)
+	out := stringRefRE.ReplaceAllStringFunc(input, func(ref string) string {
+		name := stringRefRE.FindStringSubmatch(ref)[1]
+		if _, ok := values[name]; !ok {
+			// the month macros are defined by the styles
+			if bibfuse.IsMonthMacro(name) {
+				return name
+			}
+			return ref
+		}
+		used[name] = true
+		return name
+	})
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("@string{%s = {%s}}\n", name, values[name]))
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String() + out, nil
+}
 
-	for rows.Next() {
-		row := bibfuse.NewBibItem()
-		if err := rows.Scan(
-			&row.CiteName,
-			&row.CiteType,
-			&row.Title,
-			&row.Author,
-			&row.Booktitle,
-			&row.DOI,
-			&row.Edition,
-			&row.ISBN,
-			&row.ISSN,
-			&row.Institution,
-			&row.Journal,
-			&row.Metanote,
-			&row.Note,
-			&row.Number,
-			&row.Numpages,
-			&row.Pages,
-			&row.Publisher,
-			&row.School,
-			&row.Series,
-			&row.TechreportType,
-			&row.URL,
-			&row.Version,
-			&row.Volume,
-			&row.Year,
-		); err != nil {
-			return "", 0, err
+// hideFields removes the empty fields unless opts.showEmpty, and the placeholders
+// with opts.noOptional and opts.noTodo from the entry before it is written
+func hideFields(entry *bibtex.BibEntry, opts options) {
+	for key, value := range entry.Fields {
+		switch value.String() {
+		case "":
+			if !opts.showEmpty {
+				delete(entry.Fields, key)
+			}
+		case "(OPTIONAL)":
+			if opts.noOptional {
+				delete(entry.Fields, key)
+			}
+		case "(TODO)":
+			if opts.noTodo {
+				delete(entry.Fields, key)
+			}
 		}
-		entry := row.ToBibEntry()
-		bib.AddEntry(entry)
 	}
+}
 
-	if err := rows.Err(); err != nil {
-		return "", 0, err
+// cleanBackslashes minimizes the sequences of backslashes in the values of the entry,
+// e.g., `\\cite` to `\cite`, as the bibliography has always been written
+func cleanBackslashes(entry *bibtex.BibEntry) {
+	for key, value := range entry.Fields {
+		if c, ok := value.(bibtex.BibConst); ok {
+			entry.Fields[key] = bibtex.NewBibConst(bibfuse.BackslashCleaner(string(c)))
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path,
+// so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	outString := applyOutputFilters(bib.PrettyString(), opts)
-	outString = bibfuse.BackslashCleaner(outString)
-	return outString, len(bib.Entries), nil
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func applyOutputFilters(input string, opts options) string {
-	out := input
-	if opts.noOptional {
-		out = optionalLineRE.ReplaceAllString(out, "")
+// writeOutput writes content to path unless the file already has the same content,
+// so that tools watching the file (e.g., latexmk) are not triggered for nothing
+func writeOutput(path string, content []byte) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, content, 0o644); err != nil {
+		return false, err
 	}
-	if opts.noTodo {
-		out = todoLineRE.ReplaceAllString(out, "")
+	return true, nil
+}
+
+// checkOutput returns an error if the file at path differs from content
+func checkOutput(path string, content []byte) error {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s is stale: %w", path, err)
 	}
-	if !opts.showEmpty {
-		out = emptyLineRE.ReplaceAllString(out, "")
+	if !bytes.Equal(current, content) {
+		return fmt.Errorf("%s is stale relative to the database", path)
 	}
-	return out
+	log.Printf("%s is up to date", path)
+	return nil
 }
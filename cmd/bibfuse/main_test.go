@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iomz/bibfuse"
+)
+
+var formatbibliographytests = []struct {
+	title string
+	opts  options
+	wrap  int
+	out   string
+}{
+	{
+		`The \TeX{}book companion`,
+		options{},
+		0,
+		"@misc{knuth1984,\n    title  = {The \\TeX{}book companion},\n    author = \"Knuth, Donald E.\",\n    note   = \"(OPTIONAL)\",\n    year   = \"(TODO)\",\n}\n",
+	},
+	{
+		`Typesetting with \LaTeX{} in Kj{\o{}}benhavn`,
+		options{noOptional: true},
+		0,
+		"@misc{knuth1984,\n    title  = {Typesetting with \\LaTeX{} in Kj{\\o{}}benhavn},\n    author = \"Knuth, Donald E.\",\n    year   = \"(TODO)\",\n}\n",
+	},
+	{
+		`S\o{}ren's {}`,
+		options{noOptional: true, noTodo: true},
+		0,
+		"@misc{knuth1984,\n    title  = {S\\o{}ren's {}},\n    author = \"Knuth, Donald E.\",\n}\n",
+	},
+	{
+		"A long title wrapped over the lines of \\LaTeX{} output",
+		options{noTodo: true},
+		40,
+		"@misc{knuth1984,\n    title  = {A long title wrapped over\n              the lines of \\LaTeX{}\n              output},\n    author = \"Knuth, Donald E.\",\n    note   = \"(OPTIONAL)\",\n}\n",
+	},
+}
+
+func TestFormatBibliography(t *testing.T) {
+	for _, tt := range formatbibliographytests {
+		bi := bibfuse.NewBibItem()
+		bi.CiteName = "knuth1984"
+		bi.CiteType = "misc"
+		bi.Title = tt.title
+		bi.Author = "Knuth, Donald E."
+		bi.Note = "(OPTIONAL)"
+		bi.Year = "(TODO)"
+		tt.opts.writeOptions = bibfuse.DefaultWriteOptions()
+		tt.opts.writeOptions.Wrap = tt.wrap
+		out, err := formatBibliography(nil, []bibfuse.BibItem{bi}, tt.opts)
+		if err != nil {
+			t.Errorf("formatBibliography(%v) err => %v, want nil", tt.title, err)
+		}
+		if out != tt.out {
+			t.Errorf("formatBibliography(%v) => %q, want %q", tt.title, out, tt.out)
+		}
+	}
+}
+
+func TestFormatBibliographyBackslashes(t *testing.T) {
+	bi := bibfuse.NewBibItem()
+	bi.CiteName = "knuth1984"
+	bi.CiteType = "misc"
+	bi.Title = `The \\TeX{}book`
+	bi.Author = "Knuth, Donald E."
+	bi.Note = `see \\cite{lamport1994} and \url{x}`
+	opts := options{noOptional: true, noTodo: true, writeOptions: bibfuse.DefaultWriteOptions()}
+	out, err := formatBibliography(nil, []bibfuse.BibItem{bi}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "@misc{knuth1984,\n    title  = {The \\TeX{}book},\n    author = \"Knuth, Donald E.\",\n    note   = {see \\cite{lamport1994} and \\url{x}},\n}\n"
+	if out != want {
+		t.Errorf("formatBibliography(%v) => %q, want %q", bi.Note, out, want)
+	}
+}
+
+func TestExportBibliographyPreambles(t *testing.T) {
+	db := openTestDB(t)
+	insertTestEntries(t, db, newTestEntry(t, "a", "misc", "title", "A", "year", "2020"))
+	opts := options{noOptional: true, noTodo: true, writeOptions: bibfuse.DefaultWriteOptions()}
+	entry := "@misc{a,\n    title = \"A\",\n    year  = 2020,\n}\n"
+
+	content, count, err := exportBibliography(db, opts)
+	if err != nil || count != 1 || content != entry {
+		t.Errorf("exportBibliography() => %q, %v, %v, want %q, 1, nil", content, count, err, entry)
+	}
+
+	// the preambles of the files imported come first
+	storeTestBlocks(t, db, "a.bib", "@preamble{\"\\noop\"}\n% a comment\n@preamble{\"\\def\\x{y}\"}\n", false)
+	want := "@preamble{\"\\noop\"}\n@preamble{\"\\def\\x{y}\"}\n\n" + entry
+	content, count, err = exportBibliography(db, opts)
+	if err != nil || count != 1 || content != want {
+		t.Errorf("exportBibliography() => %q, %v, %v, want %q, 1, nil", content, count, err, want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.bib")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	if err := writeFileAtomic(path, []byte("new"), 0o600); err != nil {
+		t.Fatalf("writeFileAtomic() err => %v, want nil", err)
+	}
+	// the file is replaced rather than rewritten, so the one opened before still reads the old content
+	if data, err := io.ReadAll(old); err != nil || string(data) != "old" {
+		t.Errorf("the file opened before writeFileAtomic() => %q, %v, want old", data, err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("writeFileAtomic() wrote %q, %v, want new", data, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("writeFileAtomic() mode => %v, want %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+	// no temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("writeFileAtomic() left %d files in the directory, want 1", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "out.bib"), []byte("new"), 0o644); err == nil {
+		t.Errorf("writeFileAtomic() to a missing directory err => nil, want an error")
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bib")
+	if written, err := writeOutput(path, []byte("a")); err != nil || !written {
+		t.Fatalf("writeOutput() of a new file => %v, %v, want true, nil", written, err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same content is not written again
+	if written, err := writeOutput(path, []byte("a")); err != nil || written {
+		t.Errorf("writeOutput() of the same content => %v, %v, want false, nil", written, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("the modification time after writeOutput() of the same content => %v, want %v", info.ModTime(), past)
+	}
+
+	if written, err := writeOutput(path, []byte("b")); err != nil || !written {
+		t.Errorf("writeOutput() of a new content => %v, %v, want true, nil", written, err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "b" {
+		t.Errorf("writeOutput() wrote %q, %v, want b", data, err)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bib")
+	if err := checkOutput(path, []byte("a")); err == nil {
+		t.Errorf("checkOutput() of a missing file err => nil, want an error")
+	}
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOutput(path, []byte("a")); err != nil {
+		t.Errorf("checkOutput() of the current file err => %v, want nil", err)
+	}
+	if err := checkOutput(path, []byte("b")); err == nil {
+		t.Errorf("checkOutput() of a stale file err => nil, want an error")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+)
+
+// mergeDriverName is the name of the merge driver in the git config and .gitattributes
+const mergeDriverName = "bibfuse"
+
+// mergeReport is the result of a merge for -format json
+type mergeReport struct {
+	File      string          `json:"file"`
+	Entries   int             `json:"entries"`
+	Conflicts []mergeConflict `json:"conflicts"`
+}
+
+// mergeConflict is an entry changed differently in ours and theirs, the fields are empty
+// if the entry is deleted in either
+type mergeConflict struct {
+	Key    string   `json:"key"`
+	Fields []string `json:"fields"`
+}
+
+// installReport is the result of git install-driver for -format json
+type installReport struct {
+	Driver     string `json:"driver"`
+	Attributes string `json:"attributes"`
+	Added      bool   `json:"added"` // false if .gitattributes already has the line
+}
+
+func runMergeDriver(args []string) error {
+	fs := flag.NewFlagSet("merge-driver", flag.ExitOnError)
+	var opts options
+	bindWriteFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s merge-driver: [options] base ours theirs\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Merge the three versions of a .bib file entry by entry and field by field, and write the result")
+		fmt.Fprintln(os.Stderr, "to ours with conflict markers around the conflicting fields (git passes them as %O %A %B).")
+		fmt.Fprintln(os.Stderr, "The comments, @preamble, and @string come first, followed by the entries sorted by the cite")
+		fmt.Fprintln(os.Stderr, "names in the style of the resulting bibtex; the entries failing to parse or with # concatenations")
+		fmt.Fprintln(os.Stderr, "are merged as they are written.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := loadWriteOptions(&opts); err != nil {
+		return err
+	}
+
+	versions := make([][]byte, 3)
+	for i, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		versions[i] = data
+	}
+	merged := bibfuse.MergeSources(versions[0], versions[1], versions[2], opts.writeOptions)
+	content, conflicts := formatMerged(merged)
+
+	// git reads the result from ours
+	ours := fs.Arg(1)
+	info, err := os.Stat(ours)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ours, []byte(content), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if opts.format.isJSON() {
+		entries := 0
+		for _, m := range merged {
+			if m.Key != "" {
+				entries++
+			}
+		}
+		if err := printJSON(mergeReport{File: ours, Entries: entries, Conflicts: conflicts}); err != nil {
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("merge: %d conflicting entries", len(conflicts))
+	}
+	return nil
+}
+
+// formatMerged returns the text of the merged blocks with the conflicting ones,
+// of which only the lines differing are in the conflict markers
+func formatMerged(merged []bibfuse.MergedBlock) (string, []mergeConflict) {
+	var sb strings.Builder
+	conflicts := []mergeConflict{}
+	for _, m := range merged {
+		if !m.IsConflict() {
+			sb.WriteString(m.Ours)
+			continue
+		}
+		switch {
+		case len(m.Conflicts) > 0:
+			logEntry(levelWarn, "", 0, m.Key, "conflict in "+strings.Join(m.Conflicts, ", "))
+		case m.Ours == "":
+			logEntry(levelWarn, "", 0, m.Key, "deleted in ours and changed in theirs")
+		case m.Theirs == "":
+			logEntry(levelWarn, "", 0, m.Key, "changed in ours and deleted in theirs")
+		default:
+			logEntry(levelWarn, "", 0, m.Key, "changed differently in ours and theirs")
+		}
+		fields := m.Conflicts
+		if fields == nil {
+			fields = []string{}
+		}
+		conflicts = append(conflicts, mergeConflict{m.Key, fields})
+		sb.WriteString(strings.Join(markConflicts(splitLines(m.Ours), splitLines(m.Theirs)), ""))
+	}
+	return sb.String(), conflicts
+}
+
+// splitLines returns the lines of text with the newlines, or nil for an empty text
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// markConflicts returns the lines common to ours and theirs with the rest in the conflict markers
+func markConflicts(ours, theirs []string) []string {
+	var lines, oursHunk, theirsHunk []string
+	flush := func() {
+		if len(oursHunk) == 0 && len(theirsHunk) == 0 {
+			return
+		}
+		lines = append(lines, "<<<<<<< ours\n")
+		lines = append(lines, oursHunk...)
+		lines = append(lines, "=======\n")
+		lines = append(lines, theirsHunk...)
+		lines = append(lines, ">>>>>>> theirs\n")
+		oursHunk, theirsHunk = nil, nil
+	}
+	for _, op := range diffLines(ours, theirs) {
+		switch op.kind {
+		case '-':
+			oursHunk = append(oursHunk, op.text)
+		case '+':
+			theirsHunk = append(theirsHunk, op.text)
+		default:
+			flush()
+			lines = append(lines, op.text)
+		}
+	}
+	flush()
+	return lines
+}
+
+// lineOp is a line kept (' '), removed ('-'), or added ('+')
+type lineOp struct {
+	kind byte
+	text string
+}
+
+// diffLines returns the lines of a and b with their longest common subsequence kept, and the
+// rest removed from a before added from b; a and b are as short as an entry, or a hunk of them
+func diffLines(a, b []string) []lineOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []lineOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, lineOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, lineOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, lineOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, lineOp{'+', b[j]})
+	}
+	return ops
+}
+
+func runGit(args []string) error {
+	fs := flag.NewFlagSet("git", flag.ExitOnError)
+	pattern := fs.String("pattern", "*.bib", "The files merged by bibfuse in .gitattributes.")
+	command := fs.String("command", "bibfuse", "The bibfuse command run by git.")
+	var format outputFormat
+	bindReportFlags(fs, &format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s git: [options] install-driver [merge-driver options]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  install-driver\n        Merge the .bib files with bibfuse merge-driver and its options in the git repository.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.Arg(0) != "install-driver" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, err := installMergeDriver(*pattern, strings.Join(append([]string{*command, "merge-driver"}, fs.Args()[1:]...), " "))
+	if err != nil {
+		return err
+	}
+	if format.isJSON() {
+		return printJSON(report)
+	}
+	return nil
+}
+
+// installMergeDriver defines the merge driver running command in the git config of the repository,
+// and assigns it to the files matching pattern in .gitattributes
+func installMergeDriver(pattern, command string) (installReport, error) {
+	report := installReport{Driver: command + " %O %A %B"}
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return report, fmt.Errorf("git: not in a repository: %w", err)
+	}
+	for _, config := range [][]string{
+		{"merge." + mergeDriverName + ".name", "bibfuse three-way merge of .bib files"},
+		{"merge." + mergeDriverName + ".driver", report.Driver},
+	} {
+		if out, err := exec.Command("git", "config", config[0], config[1]).CombinedOutput(); err != nil {
+			return report, fmt.Errorf("git config %s: %v: %s", config[0], err, out)
+		}
+	}
+
+	report.Attributes = filepath.Join(strings.TrimSpace(string(top)), ".gitattributes")
+	attributes, err := os.ReadFile(report.Attributes)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return report, err
+	}
+	line := pattern + " merge=" + mergeDriverName
+	for _, existing := range strings.Split(string(attributes), "\n") {
+		if strings.TrimSpace(existing) == line {
+			log.Printf("the merge driver installed, %s already has %s", report.Attributes, line)
+			return report, nil
+		}
+	}
+	if len(attributes) > 0 && !strings.HasSuffix(string(attributes), "\n") {
+		attributes = append(attributes, '\n')
+	}
+	attributes = append(attributes, line+"\n"...)
+	if err := writeFileAtomic(report.Attributes, attributes, 0o644); err != nil {
+		return report, err
+	}
+	report.Added = true
+	log.Printf("the merge driver installed, %s added to %s", line, report.Attributes)
+	return report, nil
+}
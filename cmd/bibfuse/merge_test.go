@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+func TestFormatMerged(t *testing.T) {
+	base := "@misc{a,\n  title = {A},\n  year = 2019,\n}\n\n@misc{b,\n  title = {B},\n}\n"
+	ours := "@misc{a,\n  title = {A},\n  year = 2020,\n}\n"
+	theirs := "@misc{a,\n  title = {A},\n  year = 2021,\n}\n\n@misc{b,\n  title = {B2},\n}\n"
+
+	content, conflicts := formatMerged(bibfuse.MergeSources([]byte(base), []byte(ours), []byte(theirs), bibfuse.DefaultWriteOptions()))
+	want := "@misc{a,\n    title = \"A\",\n" +
+		"<<<<<<< ours\n    year  = 2020,\n=======\n    year  = 2021,\n>>>>>>> theirs\n" +
+		"}\n\n" +
+		"<<<<<<< ours\n=======\n@misc{b,\n    title = \"B2\",\n}\n>>>>>>> theirs\n"
+	if content != want {
+		t.Errorf("formatMerged() => %q, want %q", content, want)
+	}
+	wantConflicts := []mergeConflict{{"a", []string{"year"}}, {"b", []string{}}}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("formatMerged() conflicts => %v, want %v", conflicts, wantConflicts)
+	}
+}
+
+var difflinestests = []struct {
+	a, b []string
+	ops  string
+}{
+	{nil, nil, ""},
+	{[]string{"a", "b"}, nil, "-a-b"},
+	{nil, []string{"a", "b"}, "+a+b"},
+	{[]string{"a", "b", "c"}, []string{"a", "c"}, " a-b c"},
+	// the lines removed come before the ones added
+	{[]string{"a", "b", "c"}, []string{"a", "x", "c"}, " a-b+x c"},
+	{[]string{"a", "b", "c", "d"}, []string{"b", "x", "d", "e"}, "-a b-c+x d+e"},
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range difflinestests {
+		var sb strings.Builder
+		for _, op := range diffLines(tt.a, tt.b) {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+		}
+		if sb.String() != tt.ops {
+			t.Errorf("diffLines(%q, %q) => %q, want %q", tt.a, tt.b, sb.String(), tt.ops)
+		}
+	}
+}
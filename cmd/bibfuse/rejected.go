@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+)
+
+func runRejected(args []string) error {
+	fs := flag.NewFlagSet("rejected", flag.ExitOnError)
+	opts := options{}
+	bindImportFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	showText := fs.Bool("text", false, "Print the entries as they are in the files with ls.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s rejected: [options] [ls|retry] [key ...]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  ls [key ...]\n        List the entries rejected in the last import of their files with the reasons (default).")
+		fmt.Fprintln(os.Stderr, "  retry [key ...]\n        Import the files of the entries rejected again after fixing them, with the import options.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	action := "ls"
+	if fs.Arg(0) == "ls" || fs.Arg(0) == "retry" {
+		action = fs.Arg(0)
+		// the options can follow the action as well
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	keys := fs.Args()
+	opts.useDefaultConfig = opts.config == defaultConfigFile
+
+	db, err := createDB(filepath.Join(".", opts.dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	rejects, err := loadRejected(db)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		selected := rejects[:0]
+		for _, re := range rejects {
+			if containsString(keys, re.Key) {
+				selected = append(selected, re)
+			}
+		}
+		rejects = selected
+	}
+
+	if action == "ls" {
+		if opts.format.isJSON() {
+			if rejects == nil {
+				rejects = []rejectedEntry{}
+			}
+			return printJSON(rejects)
+		}
+		for _, re := range rejects {
+			fmt.Printf("%s:%d: [%s] %s\n", re.File, re.Line, re.Key, re.Reason)
+			if *showText {
+				fmt.Printf("    %s\n", strings.ReplaceAll(re.Text, "\n", "\n    "))
+			}
+		}
+		return nil
+	}
+
+	if len(rejects) == 0 {
+		log.Printf("no entries rejected to retry")
+		return nil
+	}
+
+	if err := configureViper(opts); err != nil {
+		return err
+	}
+	filters, oneofs, err := loadRules()
+	if err != nil {
+		return err
+	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+	stats, err := retryRejected(db, filters, oneofs, opts, rejects)
+	if opts.format.isJSON() {
+		if printErr := printJSON(stats.report(err)); printErr != nil {
+			return printErr
+		}
+	}
+	if err != nil && !opts.partial {
+		return err
+	}
+	log.Printf("+%d new entries, %d still rejected", stats.added, stats.invalid)
+	return err
+}
+
+// retryRejected imports the files of the entries rejected again, which replaces their rejected entries
+func retryRejected(db *sql.DB, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options, rejects []rejectedEntry) (importStats, error) {
+	var files []string
+	for _, re := range rejects {
+		if !containsString(files, re.File) {
+			files = append(files, re.File)
+		}
+	}
+	return importBibFiles(db, filters, oneofs, opts, files)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+func TestRetryRejected(t *testing.T) {
+	path := writeTestFile(t, strictTestSource)
+	db := openTestDB(t)
+	config := bibfuse.DefaultConfig()
+	filters, oneofs := config.Filters(), config.Oneofs()
+	opts := options{tolerant: true}
+	if _, err := importBibFiles(db, filters, oneofs, opts, []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	rejects, err := loadRejected(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejects) != 1 || rejects[0].File != path {
+		t.Fatalf("loadRejected() => %v, want the entry in %v", rejects, path)
+	}
+
+	// the entry fixed in the file is imported, and no longer rejected
+	fixed := strictTestSource + "},\n  journal = {J},\n  year = 2021,\n}\n"
+	if err := os.WriteFile(path, []byte(fixed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := retryRejected(db, filters, oneofs, opts, rejects)
+	if err != nil {
+		t.Fatalf("retryRejected() err => %v, want nil", err)
+	}
+	if stats.added != 1 || stats.invalid != 0 {
+		t.Errorf("retryRejected() => %+v, want 1 added", stats)
+	}
+	if _, err := scanEntry(db.QueryRow(selectEntrySQL+" WHERE cite_name = ?", "roe2021")); err != nil {
+		t.Errorf("roe2021 after retryRejected() err => %v, want it imported", err)
+	}
+	if count := countRows(t, db, "rejected"); count != 0 {
+		t.Errorf("retryRejected() left %d rows in rejected, want 0", count)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the formats of the reports on stdout for -format, and of the logs on stderr for -log-format
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// the levels of the log records
+const (
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+// outputFormat is a flag.Value accepting text or json
+type outputFormat string
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(value string) error {
+	switch value {
+	case formatText, formatJSON:
+		*f = outputFormat(value)
+		return nil
+	}
+	return fmt.Errorf("want %s or %s", formatText, formatJSON)
+}
+
+// isJSON reports whether the report is written in JSON
+func (f outputFormat) isJSON() bool {
+	return f == formatJSON
+}
+
+// logFormat is a flag.Value switching the format of the logs as soon as it is set
+type logFormat struct{}
+
+func (logFormat) String() string {
+	if _, ok := log.Writer().(*jsonLogWriter); ok {
+		return formatJSON
+	}
+	return formatText
+}
+
+func (logFormat) Set(value string) error {
+	switch value {
+	case formatText:
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	case formatJSON:
+		log.SetOutput(&jsonLogWriter{w: os.Stderr})
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("want %s or %s", formatText, formatJSON)
+	}
+	return nil
+}
+
+// bindReportFlags defines the formats of the report on stdout and the logs on stderr on fs
+func bindReportFlags(fs *flag.FlagSet, format *outputFormat) {
+	*format = formatText
+	fs.Var(format, "format", "The format of the report on stdout (text|json).")
+	bindLogFlags(fs)
+}
+
+// bindLogFlags defines the format of the logs on stderr on fs
+func bindLogFlags(fs *flag.FlagSet) {
+	fs.Var(logFormat{}, "log-format", "The format of the logs on stderr, a JSON object per line with json (text|json).")
+}
+
+// logRecord is a line of the log with -log-format json
+type logRecord struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Key   string `json:"key,omitempty"`
+}
+
+// jsonLogWriter writes the log as JSON objects, the lines from the log package as info
+type jsonLogWriter struct {
+	mu sync.Mutex // logEntry writes the records without log.Logger
+	w  io.Writer
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	if err := w.writeRecord(logRecord{Level: levelInfo, Msg: strings.TrimRight(string(p), "\n")}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeRecord writes the record at the time
+func (w *jsonLogWriter) writeRecord(record logRecord) error {
+	record.Time = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(data, '\n'))
+	return err
+}
+
+// logEntry logs msg at the level about the entry with the cite name at the line of the file,
+// the ones unknown are left empty or 0; the text starts with them, e.g., `a.bib:3: [key] msg`
+func logEntry(level, file string, line int, key, msg string) {
+	if w, ok := log.Writer().(*jsonLogWriter); ok {
+		w.writeRecord(logRecord{Level: level, Msg: msg, File: file, Line: line, Key: key})
+		return
+	}
+	var sb strings.Builder
+	if file != "" {
+		sb.WriteString(file)
+		if line > 0 {
+			fmt.Fprintf(&sb, ":%d", line)
+		}
+		sb.WriteString(": ")
+	}
+	if key != "" {
+		fmt.Fprintf(&sb, "[%s] ", key)
+	}
+	sb.WriteString(msg)
+	log.Print(sb.String())
+}
+
+// logError logs err at the error level
+func logError(err error) {
+	logEntry(levelError, "", 0, "", err.Error())
+}
+
+// fatal logs the error and exits, the failures in an import as a record each with -log-format json
+func fatal(err error) {
+	var failures importErrors
+	if _, ok := log.Writer().(*jsonLogWriter); ok && errors.As(err, &failures) {
+		for _, failure := range failures {
+			logEntry(levelError, failure.file, 0, failure.citeName, failure.err.Error())
+		}
+	} else {
+		logError(err)
+	}
+	os.Exit(1)
+}
+
+// printJSON writes v to stdout indented
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(out))
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+var logentrytests = []struct {
+	level string
+	file  string
+	line  int
+	key   string
+	msg   string
+	text  string
+}{
+	{levelWarn, "refs.bib", 2, "smith2020", "no dot in abbreviation: J Smith, J", "refs.bib:2: [smith2020] no dot in abbreviation: J Smith, J\n"},
+	{levelInfo, "refs.bib", 0, "a", "added to the database", "refs.bib: [a] added to the database\n"},
+	{levelWarn, "", 0, "a", "conflict in year", "[a] conflict in year\n"},
+	{levelError, "", 0, "", "no such file: a.bib: [x] y", "no such file: a.bib: [x] y\n"},
+}
+
+func TestLogEntry(t *testing.T) {
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	for _, tt := range logentrytests {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		log.SetFlags(0)
+		logEntry(tt.level, tt.file, tt.line, tt.key, tt.msg)
+		if buf.String() != tt.text {
+			t.Errorf("logEntry(%v, %v, %v, %v, %v) => %q, want %q", tt.level, tt.file, tt.line, tt.key, tt.msg, buf.String(), tt.text)
+		}
+
+		buf.Reset()
+		log.SetOutput(&jsonLogWriter{w: &buf})
+		logEntry(tt.level, tt.file, tt.line, tt.key, tt.msg)
+		var record logRecord
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("logEntry(%v) => %q, want a JSON object: %v", tt.msg, buf.String(), err)
+		}
+		record.Time = ""
+		want := logRecord{Level: tt.level, Msg: tt.msg, File: tt.file, Line: tt.line, Key: tt.key}
+		if record != want {
+			t.Errorf("logEntry(%v) => %+v, want %+v", tt.msg, record, want)
+		}
+	}
+}
+
+func TestJSONLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &jsonLogWriter{w: &buf}
+	// the lines from the log package are not parsed for the file, the line, or the cite name
+	if _, err := w.Write([]byte("refs.bib:2: [a] parsed\n")); err != nil {
+		t.Fatal(err)
+	}
+	var record logRecord
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Level != levelInfo || record.Msg != "refs.bib:2: [a] parsed" || record.File != "" || record.Key != "" {
+		t.Errorf("Write() => %+v, want the info record of the line", record)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("Write() => %q, want a record per line", buf.String())
+	}
+}
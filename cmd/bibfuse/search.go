@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/iomz/bibfuse"
+)
+
+// the output formats of search
+const (
+	formatTable  = "table"
+	formatKeys   = "keys"
+	formatBibtex = "bibtex"
+)
+
+// searchResult is an entry found for -format json
+type searchResult struct {
+	Key    string            `json:"key"`
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields"` // the fields with the values, the empty ones omitted
+}
+
+// searchColumns are the columns searched for bibfuse.SearchFields without FTS5, without the
+// placeholders as they are indexed with FTS5
+var searchColumns = map[string][]string{
+	"title":    {searchableTitle},
+	"author":   {searchableAuthor},
+	"venue":    {searchableVenue},
+	"note":     {searchableNote},
+	"metanote": {searchableMetanote},
+}
+
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	opts := options{}
+	fs.StringVar(&opts.dbFile, "db", defaultDBFile, "The SQLite file to read/write.")
+	fs.StringVar(&opts.venuesFile, "venues", defaultVenuesFile, "The venue registry normalizing the journal and booktitle.")
+	bindOutputFlags(fs, &opts)
+	format := fs.String("format", formatTable, "The output format (table|keys|bibtex|json).")
+	bindLogFlags(fs)
+	limit := fs.Int("limit", 20, "The maximum number of entries to show, or 0 for all.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s search: [options] query\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  query\n        The terms and the qualifiers, e.g., rfid author:mizutani year:2019..2021 type:inproceedings")
+		fmt.Fprintln(os.Stderr, "        (author, title, venue, journal, booktitle, note, metanote, year, type, and key).")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch *format {
+	case formatTable, formatKeys, formatBibtex, formatJSON:
+	default:
+		return fmt.Errorf("-format %q: want %s, %s, %s, or %s", *format, formatTable, formatKeys, formatBibtex, formatJSON)
+	}
+	opts.titleWordsFile = defaultTitleWordsFile
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+
+	query, err := bibfuse.ParseSearchQuery(strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	db, err := createDB(filepath.Join(".", opts.dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	items, err := searchEntries(db, query, *limit)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case formatKeys:
+		for _, bi := range items {
+			fmt.Println(bi.CiteName)
+		}
+	case formatBibtex:
+		content, err := formatBibliography(db, items, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Print(content)
+	case formatJSON:
+		results := make([]searchResult, 0, len(items))
+		for _, bi := range items {
+			fields := make(map[string]string)
+			for name, value := range bi.AllFields(bibfuse.ByBibTexName) {
+				if value != "" && name != "cite_name" && name != "cite_type" {
+					fields[name] = value
+				}
+			}
+			results = append(results, searchResult{bi.CiteName, bi.CiteType, fields})
+		}
+		return printJSON(results)
+	default:
+		printEntryTable(items)
+	}
+	return nil
+}
+
+// searchEntries returns the entries matching the query, ranked by FTS5 if available
+func searchEntries(db *sql.DB, query *bibfuse.SearchQuery, limit int) ([]bibfuse.BibItem, error) {
+	indexed, err := updateSearchIndex(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	var args []interface{}
+	var conds []string
+	order := " ORDER BY year DESC, cite_name ASC"
+	sb.WriteString(selectEntrySQL)
+
+	if expr := query.MatchExpression(); expr != "" {
+		if indexed {
+			sb.WriteString(" JOIN (SELECT rowid AS match_id, rank FROM entries_fts WHERE entries_fts MATCH ?) ON match_id = entries.id")
+			args = append(args, expr)
+			order = " ORDER BY rank, cite_name ASC"
+		} else {
+			for _, term := range query.Terms {
+				var columns []string
+				for _, field := range bibfuse.SearchFields {
+					columns = append(columns, searchColumns[field]...)
+				}
+				conds, args = likeCondition(conds, args, columns, term)
+			}
+			for field, terms := range query.Fields {
+				for _, term := range terms {
+					conds, args = likeCondition(conds, args, searchColumns[field], term)
+				}
+			}
+		}
+	}
+
+	if len(query.Types) > 0 {
+		conds = append(conds, "cite_type IN (?"+strings.Repeat(", ?", len(query.Types)-1)+")")
+		for _, citeType := range query.Types {
+			args = append(args, citeType)
+		}
+	}
+	if len(query.Keys) > 0 {
+		var likes []string
+		for _, key := range query.Keys {
+			likes = append(likes, "cite_name LIKE ?")
+			args = append(args, key+"%")
+		}
+		conds = append(conds, "("+strings.Join(likes, " OR ")+")")
+	}
+	if query.YearFrom > 0 {
+		conds = append(conds, "CAST(year AS INTEGER) >= ?")
+		args = append(args, query.YearFrom)
+	}
+	if query.YearTo > 0 {
+		conds = append(conds, "CAST(year AS INTEGER) BETWEEN 1 AND ?")
+		args = append(args, query.YearTo)
+	}
+
+	if len(conds) > 0 {
+		sb.WriteString(" WHERE " + strings.Join(conds, " AND "))
+	}
+	sb.WriteString(order)
+	if limit > 0 {
+		sb.WriteString(fmt.Sprintf(" LIMIT %d", limit))
+	}
+	return queryEntries(db, sb.String(), args...)
+}
+
+// likeCondition adds the condition that any of the columns contains the term
+func likeCondition(conds []string, args []interface{}, columns []string, term string) ([]string, []interface{}) {
+	var likes []string
+	for _, column := range columns {
+		likes = append(likes, column+" LIKE ?")
+		args = append(args, "%"+term+"%")
+	}
+	return append(conds, "("+strings.Join(likes, " OR ")+")"), args
+}
+
+// updateSearchIndex rebuilds the FTS5 index if the generation of the entries has changed since it was built,
+// it returns false if SQLite is built without FTS5
+func updateSearchIndex(db *sql.DB) (bool, error) {
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return false, err
+	}
+	if !fts5 {
+		logEntry(levelWarn, "", 0, "", "FTS5 unavailable (build with -tags sqlite_fts5), searching without the index")
+		return false, nil
+	}
+	if _, err := db.Exec(createSearchIndexSQL); err != nil {
+		return false, err
+	}
+
+	var generation, indexed int64
+	if err := db.QueryRow(selectGenerationSQL).Scan(&generation); err != nil {
+		return false, err
+	}
+	err := db.QueryRow("SELECT generation FROM search_state").Scan(&indexed)
+	if err == nil && indexed == generation {
+		return true, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(rebuildSearchIndexSQL); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err := tx.Exec("INSERT INTO search_state (generation) VALUES (?)", generation); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// printEntryTable prints the key, year, type, authors, and title of the entries
+func printEntryTable(items []bibfuse.BibItem) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tYEAR\tTYPE\tAUTHOR\tTITLE")
+	for _, bi := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", bi.CiteName, bi.Year, bi.CiteType, truncate(bi.Author, 30), truncate(bi.Title, 60))
+	}
+	w.Flush()
+}
+
+// truncate shortens s to n runes with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
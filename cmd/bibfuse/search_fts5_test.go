@@ -0,0 +1,94 @@
+//go:build sqlite_fts5
+// +build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+// countIndexed returns the number of the entries in the search index
+func countIndexed(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM entries_fts").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUpdateSearchIndex(t *testing.T) {
+	db := openTestDB(t)
+	insertTestEntries(t, db, searchTestEntries(t)...)
+	indexed, err := updateSearchIndex(db)
+	if err != nil || !indexed {
+		t.Fatalf("updateSearchIndex() => %v, %v, want true, nil", indexed, err)
+	}
+	if count := countIndexed(t, db); count != 4 {
+		t.Errorf("updateSearchIndex() indexed %d entries, want 4", count)
+	}
+
+	// the index is left as it is while the entries are unchanged
+	if _, err := db.Exec("DELETE FROM entries_fts"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexed(t, db); count != 0 {
+		t.Errorf("updateSearchIndex() of the unchanged entries indexed %d entries, want 0", count)
+	}
+	// including the duplicates imported again
+	insertTestEntries(t, db, searchTestEntries(t)...)
+	if _, err := updateSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexed(t, db); count != 0 {
+		t.Errorf("updateSearchIndex() of the duplicates indexed %d entries, want 0", count)
+	}
+
+	// and rebuilt when they change
+	insertTestEntries(t, db, newTestEntry(t, "muller2022", "misc", "title", "Über RFID", "author", "Müller, Jürgen", "year", "2022"))
+	if _, err := updateSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if count := countIndexed(t, db); count != 5 {
+		t.Errorf("updateSearchIndex() of the changed entries indexed %d entries, want 5", count)
+	}
+}
+
+var searchrankedtests = []struct {
+	query string
+	first string // the entry ranked first
+	count int
+}{
+	// the entry matching in both the title and the venue comes first
+	{"rfid", "mizutani2019rfid", 3},
+	// the diacritics are ignored
+	{"author:muller uber", "muller2022", 1},
+	// the terms match the prefixes of the words
+	{"optimiz", "roe2020", 1},
+}
+
+func TestSearchEntriesRanked(t *testing.T) {
+	db := openTestDB(t)
+	insertTestEntries(t, db, searchTestEntries(t)...)
+	insertTestEntries(t, db, newTestEntry(t, "muller2022", "misc", "title", "Über Systems", "author", "Müller, Jürgen", "note", "Not about rfid", "year", "2022"))
+	for _, tt := range searchrankedtests {
+		query, err := bibfuse.ParseSearchQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := searchEntries(db, query, 0)
+		if err != nil {
+			t.Errorf("searchEntries(%v) err => %v, want nil", tt.query, err)
+			continue
+		}
+		if len(items) != tt.count || items[0].CiteName != tt.first {
+			t.Errorf("searchEntries(%v) => %d entries, want %d with %v first", tt.query, len(items), tt.count, tt.first)
+		}
+	}
+}
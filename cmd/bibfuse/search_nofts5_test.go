@@ -0,0 +1,13 @@
+//go:build !sqlite_fts5
+// +build !sqlite_fts5
+
+package main
+
+import "testing"
+
+func TestUpdateSearchIndexWithoutFTS5(t *testing.T) {
+	db := openTestDB(t)
+	if indexed, err := updateSearchIndex(db); err != nil || indexed {
+		t.Errorf("updateSearchIndex() => %v, %v, want false, nil", indexed, err)
+	}
+}
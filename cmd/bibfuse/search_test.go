@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+// openTestDB returns a new database in a temporary directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := createDB(filepath.Join(t.TempDir(), "bib.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// insertTestEntries inserts the entries in the database
+func insertTestEntries(t *testing.T, db *sql.DB, items ...bibfuse.BibItem) {
+	t.Helper()
+	w, err := newEntryWriter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bi := range items {
+		if _, err := w.insertEntry(bi); err != nil {
+			w.rollback()
+			t.Fatal(err)
+		}
+	}
+	if err := w.commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestEntry returns an entry with the fields given as pairs of their names and values
+func newTestEntry(t *testing.T, citeName, citeType string, fields ...string) bibfuse.BibItem {
+	t.Helper()
+	bi := bibfuse.NewBibItem()
+	bi.CiteName = citeName
+	bi.CiteType = citeType
+	for i := 0; i+1 < len(fields); i += 2 {
+		if err := bi.SetFieldByBibTexName(fields[i], fields[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bi
+}
+
+// searchTestEntries are found by the searches both with and without FTS5
+func searchTestEntries(t *testing.T) []bibfuse.BibItem {
+	return []bibfuse.BibItem{
+		newTestEntry(t, "mizutani2019rfid", "inproceedings", "title", "Tracking RFID Tags", "author", "Mizutani, Iori", "booktitle", "IEEE RFID", "year", "2019"),
+		newTestEntry(t, "mizutani2021sensors", "article", "title", "Sensor Networks", "author", "Mizutani, Iori and Roe, Jane", "journal", "Sensors", "note", "With RFID", "year", "2021"),
+		newTestEntry(t, "roe2020", "article", "title", "Optimizing Queries", "author", "Roe, Jane", "journal", "Databases", "year", "2020"),
+		newTestEntry(t, "smith2018", "book", "title", "Wireless Systems", "author", "Smith, John", "note", "(OPTIONAL)", "year", "2018"),
+	}
+}
+
+var searchentriestests = []struct {
+	query string
+	keys  []string
+}{
+	{"rfid", []string{"mizutani2019rfid", "mizutani2021sensors"}},
+	{"RFID tracking", []string{"mizutani2019rfid"}},
+	{"author:roe", []string{"mizutani2021sensors", "roe2020"}},
+	{"venue:sensors", []string{"mizutani2021sensors"}},
+	{"title:rfid", []string{"mizutani2019rfid"}},
+	{"optional", []string{}},
+	{"year:2019..2020", []string{"mizutani2019rfid", "roe2020"}},
+	{"year:2020..", []string{"mizutani2021sensors", "roe2020"}},
+	{"type:article author:mizutani", []string{"mizutani2021sensors"}},
+	{"key:miz rfid", []string{"mizutani2019rfid", "mizutani2021sensors"}},
+	{`"sensor networks"`, []string{"mizutani2021sensors"}},
+}
+
+func TestSearchEntries(t *testing.T) {
+	db := openTestDB(t)
+	insertTestEntries(t, db, searchTestEntries(t)...)
+	for _, tt := range searchentriestests {
+		query, err := bibfuse.ParseSearchQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := searchEntries(db, query, 0)
+		if err != nil {
+			t.Errorf("searchEntries(%v) err => %v, want nil", tt.query, err)
+			continue
+		}
+		// the order is ranked with FTS5 and by the year without
+		keys := []string{}
+		for _, bi := range items {
+			keys = append(keys, bi.CiteName)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("searchEntries(%v) => %v, want %v", tt.query, keys, tt.keys)
+		}
+	}
+}
+
+func TestSearchEntriesLimit(t *testing.T) {
+	db := openTestDB(t)
+	insertTestEntries(t, db, searchTestEntries(t)...)
+	query, err := bibfuse.ParseSearchQuery("year:2018..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := searchEntries(db, query, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("searchEntries(year:2018.., 2) => %d entries, want 2", len(items))
+	}
+}
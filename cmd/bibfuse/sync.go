@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/iomz/bibfuse"
+)
+
+// blockTypeRE matches the type of an entry block
+var blockTypeRE = regexp.MustCompile(`^(\s*@\s*)[A-Za-z]+`)
+
+// syncStats counts the entries changed by a sync, it is the report for -format json as well
+type syncStats struct {
+	ToFiles   int            `json:"to_files"`
+	ToDB      int            `json:"to_db"`
+	Added     int            `json:"added"`
+	Conflicts []syncConflict `json:"conflicts"`
+}
+
+// syncConflict is a field changed differently in the database and in the file
+type syncConflict struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Key       string `json:"key"`
+	Field     string `json:"field"`
+	Database  string `json:"database"`
+	FileValue string `json:"file_value"`
+}
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	opts := options{}
+	bindImportFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	dryRun := fs.Bool("dry-run", false, "Report the changes without writing the .bib files or the database.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s sync: [options] [.bib ... .bib]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Write the changes in the database back to the .bib files the entries were imported from,")
+		fmt.Fprintln(os.Stderr, "and the changes in the files to the database, all the files imported by default.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts.useDefaultConfig = opts.config == defaultConfigFile
+
+	if err := configureViper(opts); err != nil {
+		return err
+	}
+	filters, oneofs, err := loadRules()
+	if err != nil {
+		return err
+	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+
+	db, err := createDB(filepath.Join(".", opts.dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	stats, err := syncBibFiles(db, filters, oneofs, opts, fs.Args(), *dryRun)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d entries written to the files, %d to the database, +%d new entries", stats.ToFiles, stats.ToDB, stats.Added)
+	if opts.format.isJSON() {
+		if err := printJSON(stats); err != nil {
+			return err
+		}
+	}
+	if len(stats.Conflicts) > 0 {
+		return fmt.Errorf("sync: %d conflict(s) left as they are on both sides", len(stats.Conflicts))
+	}
+	return nil
+}
+
+// syncBibFiles merges the changes since the last sync in the database and in the files field by field,
+// the fields changed differently on both sides are reported and left until resolved by hand
+func syncBibFiles(db *sql.DB, filters bibfuse.Filters, oneofs bibfuse.Oneofs, opts options, files []string, dryRun bool) (syncStats, error) {
+	stats := syncStats{Conflicts: []syncConflict{}}
+	sources, err := loadSources(db)
+	if err != nil {
+		return stats, err
+	}
+	if len(files) == 0 {
+		seen := make(map[string]bool)
+		for _, src := range sources {
+			if !seen[src.file] {
+				seen[src.file] = true
+				files = append(files, src.file)
+			}
+		}
+		sort.Strings(files)
+	}
+	defined, err := loadStrings(db)
+	if err != nil {
+		return stats, err
+	}
+
+	w, err := newEntryWriter(db)
+	if err != nil {
+		return stats, err
+	}
+	// the files are written after the database is committed, so a failure changes neither
+	type fileWrite struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}
+	var writes []fileWrite
+	for _, parsed := range parseBibFiles(w, defined, filters, oneofs, opts, files) {
+		if parsed.err != nil {
+			w.rollback()
+			return stats, fmt.Errorf("%s: %w", parsed.path, parsed.err)
+		}
+		for _, err := range parsed.invalid {
+			logProblem(levelWarn, parsed, err)
+		}
+		for _, fix := range parsed.fixes {
+			logEntry(levelWarn, parsed.path, fix.Line, fix.Key, fix.String())
+		}
+		data, err := syncBibFile(w, parsed, sources, opts, &stats)
+		if err != nil {
+			w.rollback()
+			return stats, err
+		}
+		if dryRun || data == nil {
+			continue
+		}
+		info, err := os.Stat(parsed.path)
+		if err != nil {
+			w.rollback()
+			return stats, err
+		}
+		writes = append(writes, fileWrite{parsed.path, data, info.Mode().Perm()})
+	}
+
+	if dryRun {
+		return stats, w.rollback()
+	}
+	if err := w.commit(); err != nil {
+		return stats, err
+	}
+	for _, fw := range writes {
+		if err := writeFileAtomic(fw.path, fw.data, fw.perm); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// syncBibFile merges the entries in the file with the database, and returns the content
+// of the file with the entries changed in place, or nil if the file is unchanged
+func syncBibFile(w *entryWriter, parsed parsedFile, sources map[string]source, opts options, stats *syncStats) ([]byte, error) {
+	items := make(map[string]bibfuse.BibItem, len(parsed.items))
+	for _, bi := range parsed.items {
+		items[bi.CiteName] = bi
+	}
+	var missing []string
+	for citeName, src := range sources {
+		if _, ok := items[citeName]; !ok && src.file == parsed.path {
+			missing = append(missing, citeName)
+		}
+	}
+	sort.Strings(missing)
+	for _, citeName := range missing {
+		logEntry(levelWarn, parsed.path, sources[citeName].line, citeName, "not found in the file, left in the database")
+	}
+
+	blocks := bibfuse.SplitBlocks(parsed.data)
+	edits := make(map[int]string) // the texts of the blocks edited by the index
+	for _, fileItem := range parsed.items {
+		citeName := fileItem.CiteName
+		src, tracked := sources[citeName]
+		if tracked && src.file != parsed.path {
+			if opts.verbose {
+				logEntry(levelInfo, parsed.path, parsed.lines[citeName], citeName, "synced with "+src.file)
+			}
+			continue
+		}
+
+		dbItem, err := scanEntry(w.find.QueryRow(citeName))
+		if err == sql.ErrNoRows {
+			if _, err := w.insertEntry(fileItem); err != nil {
+				return nil, err
+			}
+			stats.Added++
+			logEntry(levelInfo, parsed.path, parsed.lines[citeName], citeName, "added to the database")
+			if err := w.trackSource(fileItem, parsed.path, parsed.lines[citeName], true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the database wins for the entries imported before their sources were tracked
+		if !tracked {
+			src.synced = fileItem
+		}
+
+		merged, conflicts := bibfuse.MergeBibItems(src.synced, dbItem, fileItem)
+		for _, fieldName := range conflicts {
+			dbValue, _ := dbItem.FieldValueByBibTexName(fieldName)
+			fileValue, _ := fileItem.FieldValueByBibTexName(fieldName)
+			logEntry(levelWarn, parsed.path, parsed.lines[citeName], citeName,
+				fmt.Sprintf("conflict in %s: %q in the database, %q in the file", fieldName, dbValue, fileValue))
+			stats.Conflicts = append(stats.Conflicts, syncConflict{parsed.path, parsed.lines[citeName], citeName, fieldName, dbValue, fileValue})
+		}
+
+		if changed := bibfuse.DiffFields(dbItem, merged); len(changed) > 0 {
+			if _, err := w.upsertEntry(merged); err != nil {
+				return nil, err
+			}
+			stats.ToDB++
+			logEntry(levelInfo, parsed.path, parsed.lines[citeName], citeName, strings.Join(changed, ", ")+" updated in the database")
+		}
+
+		var changed []string
+		for _, fieldName := range bibfuse.DiffFields(fileItem, merged) {
+			if !containsString(conflicts, fieldName) {
+				changed = append(changed, fieldName)
+			}
+		}
+		if len(changed) > 0 {
+			i := findBlock(blocks, citeName)
+			if i < 0 {
+				return nil, fmt.Errorf("%s: [%s] no entry block found", parsed.path, citeName)
+			}
+			text, err := editBlock(blocks[i].Text, merged, changed)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: [%s] %w", parsed.path, blocks[i].Line, citeName, err)
+			}
+			edits[i] = text
+			stats.ToFiles++
+			logEntry(levelInfo, parsed.path, parsed.lines[citeName], citeName, strings.Join(changed, ", ")+" updated in the file")
+		}
+
+		// the conflicts are found again in the next sync until resolved
+		if len(conflicts) > 0 {
+			continue
+		}
+		if err := w.trackSource(merged, parsed.path, parsed.lines[citeName], true); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	// the rest of the file is kept as it is, comments and formatting included
+	var sb strings.Builder
+	last := 0
+	for i, block := range blocks {
+		text, ok := edits[i]
+		if !ok {
+			continue
+		}
+		sb.Write(parsed.data[last:block.Offset])
+		sb.WriteString(text)
+		last = block.Offset + len(block.Text)
+	}
+	sb.Write(parsed.data[last:])
+	return []byte(sb.String()), nil
+}
+
+// findBlock returns the index of the first entry block of citeName, or -1
+func findBlock(blocks []bibfuse.Block, citeName string) int {
+	for i, block := range blocks {
+		if block.IsEntry() && block.Key() == citeName {
+			return i
+		}
+	}
+	return -1
+}
+
+// editBlock writes the fields of bi to the text of the entry block, the fields
+// with an empty value or a placeholder are removed
+func editBlock(text string, bi bibfuse.BibItem, fieldNames []string) (string, error) {
+	var err error
+	for _, fieldName := range fieldNames {
+		value, _ := bi.FieldValueByBibTexName(fieldName)
+		switch {
+		case fieldName == "cite_type":
+			text = blockTypeRE.ReplaceAllString(text, "${1}"+value)
+		case value == "" || value == "(TODO)" || value == "(OPTIONAL)":
+			text, err = bibfuse.RemoveBlockField(text, fieldName)
+		default:
+			text, err = bibfuse.SetBlockField(text, fieldName, value)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/iomz/bibfuse"
+)
+
+const syncTestSource = `% my references
+@article{smith2020,
+  author = {Smith, John},
+  title = {Tracking RFID Tags},
+  journal = {Sensors},
+  year = 2020,
+}
+`
+
+// updateTestEntry sets the fields of the entry in the database given as pairs of their names and values
+func updateTestEntry(t *testing.T, db *sql.DB, citeName string, fields ...string) {
+	t.Helper()
+	w, err := newEntryWriter(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err := scanEntry(w.find.QueryRow(citeName))
+	if err != nil {
+		w.rollback()
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if err := bi.SetFieldByBibTexName(fields[i], fields[i+1]); err != nil {
+			w.rollback()
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.upsertEntry(bi); err != nil {
+		w.rollback()
+		t.Fatal(err)
+	}
+	if err := w.commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// syncTestFile syncs the file with the database, and returns the stats and the content of the file
+func syncTestFile(t *testing.T, db *sql.DB, path string) (syncStats, string) {
+	t.Helper()
+	config := bibfuse.DefaultConfig()
+	stats, err := syncBibFiles(db, config.Filters(), config.Oneofs(), options{}, []string{path}, false)
+	if err != nil {
+		t.Fatalf("syncBibFiles() err => %v, want nil", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats, string(data)
+}
+
+func TestSyncBibFile(t *testing.T) {
+	path := writeTestFile(t, syncTestSource)
+	db := openTestDB(t)
+	config := bibfuse.DefaultConfig()
+	if _, err := importBibFiles(db, config.Filters(), config.Oneofs(), options{}, []string{path}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file untouched on both sides is left as it is
+	stats, content := syncTestFile(t, db, path)
+	if stats.ToFiles != 0 || stats.ToDB != 0 || stats.Added != 0 || len(stats.Conflicts) != 0 {
+		t.Errorf("syncBibFiles() of the untouched file => %+v, want nothing changed", stats)
+	}
+	if content != syncTestSource {
+		t.Errorf("syncBibFiles() rewrote the untouched file to %q", content)
+	}
+
+	// the change in the database is written in place, the rest of the file kept as it is
+	updateTestEntry(t, db, "smith2020", "year", "2021")
+	stats, content = syncTestFile(t, db, path)
+	if stats.ToFiles != 1 || stats.ToDB != 0 || len(stats.Conflicts) != 0 {
+		t.Errorf("syncBibFiles() of the year changed in the database => %+v, want 1 to the files", stats)
+	}
+	want := strings.Replace(syncTestSource, "year = 2020", "year = 2021", 1)
+	if content != want {
+		t.Errorf("syncBibFiles() wrote %q, want %q", content, want)
+	}
+	sources, err := loadSources(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced := sources["smith2020"].synced; synced.Year != "2021" {
+		t.Errorf("the source of smith2020 synced with the year %v, want 2021", synced.Year)
+	}
+
+	// the title changed differently on both sides is reported, and the source stays until resolved
+	updateTestEntry(t, db, "smith2020", "title", "Tracking RFID Tags in the Database")
+	conflicting := strings.Replace(want, "Tracking RFID Tags}", "Tracking RFID Tags in the File}", 1)
+	if err := os.WriteFile(path, []byte(conflicting), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats, content = syncTestFile(t, db, path)
+	wantConflict := syncConflict{path, 2, "smith2020", "title", "Tracking RFID Tags in the Database", "Tracking RFID Tags in the File"}
+	if len(stats.Conflicts) != 1 || stats.Conflicts[0] != wantConflict {
+		t.Errorf("syncBibFiles() conflicts => %+v, want %+v", stats.Conflicts, wantConflict)
+	}
+	if content != conflicting {
+		t.Errorf("syncBibFiles() of the conflict wrote %q, want %q", content, conflicting)
+	}
+	if sources, err = loadSources(db); err != nil {
+		t.Fatal(err)
+	}
+	if synced := sources["smith2020"].synced; synced.Title != "Tracking RFID Tags" {
+		t.Errorf("the source of smith2020 synced with the title %q, want the one before the conflict", synced.Title)
+	}
+	// and is found again in the next sync
+	if stats, _ = syncTestFile(t, db, path); len(stats.Conflicts) != 1 {
+		t.Errorf("syncBibFiles() again => %d conflicts, want 1", len(stats.Conflicts))
+	}
+}
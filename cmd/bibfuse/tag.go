@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func runTag(args []string) error {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	dbFile := fs.String("db", defaultDBFile, "The SQLite file to read/write.")
+	var format outputFormat
+	bindReportFlags(fs, &format)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s tag: [options] add|rm|ls [key] [tag ...]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  add key tag ...\n        Tag the entry.")
+		fmt.Fprintln(os.Stderr, "  rm key tag ...\n        Remove the tags from the entry.")
+		fmt.Fprintln(os.Stderr, "  ls [key]\n        List the tags with the number of the entries, or the tags of the entry.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	action := fs.Arg(0)
+	if action == "" || (action != "ls" && fs.NArg() < 3) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db, err := createDB(filepath.Join(".", *dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	switch action {
+	case "add":
+		if err := addTags(db, fs.Arg(1), fs.Args()[2:]); err != nil {
+			return err
+		}
+	case "rm":
+		if err := removeTags(db, fs.Arg(1), fs.Args()[2:]); err != nil {
+			return err
+		}
+	case "ls":
+		return listTags(db, fs.Arg(1), format)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !format.isJSON() {
+		return nil
+	}
+	// the tags of the entry after the change
+	tags, err := loadTags(db)
+	if err != nil {
+		return err
+	}
+	return printJSON(entryTags{Key: fs.Arg(1), Tags: append([]string{}, tags[fs.Arg(1)]...)})
+}
+
+// entryTags are the tags of an entry for -format json
+type entryTags struct {
+	Key  string   `json:"key"`
+	Tags []string `json:"tags"`
+}
+
+// tagCount is a tag with the number of the entries for -format json
+type tagCount struct {
+	Tag     string `json:"tag"`
+	Entries int    `json:"entries"`
+}
+
+// addTags tags the entry stored as citeName
+func addTags(db *sql.DB, citeName string, tags []string) error {
+	w, err := newEntryWriter(db)
+	if err != nil {
+		return err
+	}
+	if _, ok := w.findEntry(citeName); !ok {
+		w.rollback()
+		return fmt.Errorf("[%s] no such entry", citeName)
+	}
+	if err := w.addTags(citeName, tags); err != nil {
+		w.rollback()
+		return err
+	}
+	return w.commit()
+}
+
+// removeTags removes the tags from the entry
+func removeTags(db *sql.DB, citeName string, tags []string) error {
+	for _, tag := range tags {
+		res, err := db.Exec(deleteTagSQL, citeName, tag)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+			logEntry(levelWarn, "", 0, citeName, "not tagged "+tag)
+		}
+	}
+	return nil
+}
+
+// listTags prints the tags of the entry, or all the tags with the number of the entries
+func listTags(db *sql.DB, citeName string, format outputFormat) error {
+	tags, err := loadTags(db)
+	if err != nil {
+		return err
+	}
+	if citeName != "" {
+		if format.isJSON() {
+			return printJSON(entryTags{Key: citeName, Tags: append([]string{}, tags[citeName]...)})
+		}
+		for _, tag := range tags[citeName] {
+			fmt.Println(tag)
+		}
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, entryTags := range tags {
+		for _, tag := range entryTags {
+			counts[tag]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for tag := range counts {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	if format.isJSON() {
+		listed := make([]tagCount, len(names))
+		for i, tag := range names {
+			listed[i] = tagCount{tag, counts[tag]}
+		}
+		return printJSON(listed)
+	}
+	for _, tag := range names {
+		fmt.Printf("%s\t%d\n", tag, counts[tag])
+	}
+	return nil
+}
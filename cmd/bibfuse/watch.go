@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts := options{}
+	bindFlags(fs, &opts)
+	bindReportFlags(fs, &opts.format)
+	debounce := fs.Duration("debounce", 300*time.Millisecond, "Wait for the editors to finish saving before re-importing.")
+	poll := fs.Duration("poll", 0, "Poll the files at the interval instead of using inotify.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s watch: [options] .bib ... .bib\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "With -format json, the report of each import is written as a JSON object per line.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.useDefaultConfig = opts.config == defaultConfigFile
+	// the .bib files being watched are the source of their entries
+	opts.update = true
+
+	if err := configureViper(opts); err != nil {
+		return err
+	}
+	filters, oneofs, err := loadRules()
+	if err != nil {
+		return err
+	}
+	if err := loadNormalizers(&opts); err != nil {
+		return err
+	}
+
+	db, err := createDB(filepath.Join(".", opts.dbFile))
+	if err != nil {
+		return fmt.Errorf("table creation failed: %w", err)
+	}
+	defer db.Close()
+
+	reports := json.NewEncoder(os.Stdout)
+	fuse := func(changed []string) {
+		stats, err := importBibFiles(db, filters, oneofs, opts, changed)
+		report := runReport{Import: stats.report(err)}
+		if err == nil {
+			report.exportReport, err = writeBibliography(db, opts)
+		}
+		if opts.format.isJSON() {
+			if err := reports.Encode(report); err != nil {
+				logError(err)
+			}
+		}
+		if err != nil {
+			logError(err)
+			return
+		}
+		log.Printf("+%d added, ~%d updated, !%d invalid", stats.added, stats.updated, stats.invalid)
+	}
+	fuse(files)
+
+	changes := make(chan string)
+	if *poll > 0 {
+		go pollFiles(files, *poll, changes, nil)
+	} else if err := notifyFiles(files, changes); err != nil {
+		logEntry(levelWarn, "", 0, "", fmt.Sprintf("inotify unavailable, polling instead: %v", err))
+		go pollFiles(files, time.Second, changes, nil)
+	}
+	log.Printf("watching %d files", len(files))
+	collectChanges(files, changes, *debounce, fuse)
+	return nil
+}
+
+// collectChanges collects the files from changes until the editors stop saving for the debounce
+// duration, and calls fuse with them in the order of files; it returns when changes is closed
+func collectChanges(files []string, changes <-chan string, debounce time.Duration, fuse func(changed []string)) {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case file, ok := <-changes:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			pending[file] = true
+			timer.Reset(debounce)
+		case <-timer.C:
+			changed := make([]string, 0, len(pending))
+			for _, file := range files {
+				if pending[file] {
+					changed = append(changed, file)
+				}
+			}
+			pending = make(map[string]bool)
+			fuse(changed)
+		}
+	}
+}
+
+// writeBibliography exports the entries in db to the out file
+func writeBibliography(db *sql.DB, opts options) (exportReport, error) {
+	content, entryCount, err := exportBibliography(db, opts)
+	if err != nil {
+		return exportReport{}, err
+	}
+	report := exportReport{Entries: entryCount, Out: filepath.Join(".", opts.outFile)}
+	if report.Written, err = writeOutput(report.Out, []byte(content)); err != nil {
+		return report, err
+	}
+	if report.Written {
+		log.Printf("%d entries written to %s", entryCount, report.Out)
+	}
+	return report, nil
+}
+
+// notifyFiles sends the files modified to changes with inotify, it watches
+// the directories since editors often replace the file on save
+func notifyFiles(files []string, changes chan<- string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[string]string) // absolute path -> the file as given
+	for _, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		watched[absPath] = file
+		if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if file, ok := watched[filepath.Clean(event.Name)]; ok {
+					changes <- file
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// pollFiles sends the files modified to changes by checking them at the interval until done is closed
+func pollFiles(files []string, interval time.Duration, changes chan<- string, done <-chan struct{}) {
+	stamps := make(map[string]string)
+	stamp := func(file string) string {
+		info, err := os.Stat(file)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%v/%v", info.ModTime().UnixNano(), info.Size())
+	}
+	for _, file := range files {
+		stamps[file] = stamp(file)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		for _, file := range files {
+			if s := stamp(file); s != stamps[file] {
+				stamps[file] = s
+				if s == "" {
+					continue
+				}
+				select {
+				case changes <- file:
+				case <-done:
+					return
+				}
+			}
+		}
+	}
+}
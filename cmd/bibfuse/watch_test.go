@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectChanges(t *testing.T) {
+	files := []string{"a.bib", "b.bib", "c.bib"}
+	changes := make(chan string)
+	calls := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		collectChanges(files, changes, 100*time.Millisecond, func(changed []string) { calls <- changed })
+		close(done)
+	}()
+
+	// the changes saved in a row are fused at once, in the order of the files
+	for _, file := range []string{"c.bib", "a.bib", "c.bib"} {
+		changes <- file
+	}
+	select {
+	case changed := <-calls:
+		if want := []string{"a.bib", "c.bib"}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("collectChanges() fused %v, want %v", changed, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collectChanges() fused nothing, want a.bib and c.bib")
+	}
+
+	// and the ones fused are not pending any more
+	changes <- "b.bib"
+	select {
+	case changed := <-calls:
+		if want := []string{"b.bib"}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("collectChanges() fused %v, want %v", changed, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collectChanges() fused nothing, want b.bib")
+	}
+
+	close(changes)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("collectChanges() did not return after the changes are closed")
+	}
+	if len(calls) != 0 {
+		t.Errorf("collectChanges() fused %d times more, want none", len(calls))
+	}
+}
+
+func TestPollFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.bib"), filepath.Join(dir, "b.bib")
+	for _, file := range []string{a, b} {
+		if err := os.WriteFile(file, []byte("@misc{a}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go pollFiles([]string{a, b}, 10*time.Millisecond, changes, done)
+
+	expectChange := func(want string) {
+		t.Helper()
+		select {
+		case file := <-changes:
+			if file != want {
+				t.Errorf("pollFiles() => %v, want %v", file, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pollFiles() sent nothing, want %v", want)
+		}
+	}
+	expectNoChange := func() {
+		t.Helper()
+		select {
+		case file := <-changes:
+			t.Errorf("pollFiles() => %v, want nothing", file)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the files untouched are not sent
+	expectNoChange()
+
+	// a file is sent when the modification time or the size changes
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(b, future, future); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(b)
+	if err := os.WriteFile(a, []byte("@misc{a}\n@misc{b}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(a)
+	expectNoChange()
+
+	// a file removed is not sent until it is written again
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	expectNoChange()
+	if err := os.WriteFile(a, []byte("@misc{a}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(a)
+}
@@ -0,0 +1,391 @@
+package bibfuse
+
+import (
+	"bytes"
+	_ "embed" // for the built-in bibfuse.toml
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultConfigSource is the source name of the built-in config
+const DefaultConfigSource = "(built-in)"
+
+//go:embed bibfuse.toml
+var defaultConfigTOML []byte
+
+// Config holds the rules for each citation type loaded from a config file
+// and the files it extends
+type Config struct {
+	Types   map[string]*TypeConfig
+	Sources []string // the loaded files, from the base to the overlay
+	unknown []*ConfigError
+}
+
+// TypeConfig holds the rules (todos, optionals, and oneof_*) of a citation type
+type TypeConfig struct {
+	Inherits string
+	Rules    map[string][]string
+	origins  map[string]string // rule key -> the file defining it
+	replaced map[string]bool   // rule key -> whether it replaces one in a base file
+}
+
+// NewConfig initialize a Config
+func NewConfig() *Config {
+	return &Config{Types: make(map[string]*TypeConfig)}
+}
+
+func newTypeConfig() *TypeConfig {
+	return &TypeConfig{
+		Rules:    make(map[string][]string),
+		origins:  make(map[string]string),
+		replaced: make(map[string]bool),
+	}
+}
+
+// Origin returns the file where the rule key is defined
+func (tc *TypeConfig) Origin(key string) string {
+	return tc.origins[key]
+}
+
+// LoadConfig reads the config file and the chain of files listed in its `extends`
+func LoadConfig(path string) (*Config, error) {
+	c := NewConfig()
+	if err := c.load(path, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadConfig reads a config of the configType (e.g., toml) from r,
+// the files in its `extends` are relative to the working directory
+func ReadConfig(r io.Reader, configType, source string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(r); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	c := NewConfig()
+	if err := c.extend(v, source, ".", nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DefaultConfig returns the built-in config
+func DefaultConfig() *Config {
+	c, err := ReadConfig(bytes.NewReader(defaultConfigTOML), "toml", DefaultConfigSource)
+	if err != nil {
+		panic(fmt.Sprintf("broken built-in config: %v", err))
+	}
+	return c
+}
+
+// DefaultConfigTOML returns the content of the built-in bibfuse.toml
+func DefaultConfigTOML() []byte {
+	return append([]byte(nil), defaultConfigTOML...)
+}
+
+// DefaultFilters returns the Filters of the built-in config
+func DefaultFilters() Filters {
+	return resolvedDefaultConfig().Filters()
+}
+
+// DefaultOneofs returns the Oneofs of the built-in config
+func DefaultOneofs() Oneofs {
+	return resolvedDefaultConfig().Oneofs()
+}
+
+func resolvedDefaultConfig() *Config {
+	c, err := DefaultConfig().Resolve()
+	if err != nil {
+		panic(fmt.Sprintf("broken built-in config: %v", err))
+	}
+	return c
+}
+
+func (c *Config) load(path string, chain []string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range chain {
+		if p == absPath {
+			return fmt.Errorf("config: circular extends %v", strings.Join(append(chain, absPath), " -> "))
+		}
+	}
+
+	v := viper.New()
+	v.SetConfigFile(absPath)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return c.extend(v, absPath, filepath.Dir(absPath), chain)
+}
+
+// extend loads the files in the `extends` of v relative to dir, then merges v
+func (c *Config) extend(v *viper.Viper, source, dir string, chain []string) error {
+	for _, base := range v.GetStringSlice("extends") {
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(dir, base)
+		}
+		if err := c.load(base, append(chain, source)); err != nil {
+			return err
+		}
+	}
+
+	c.merge(v, source)
+	return nil
+}
+
+// merge overlays the rules in v on top of the ones already loaded
+func (c *Config) merge(v *viper.Viper, source string) {
+	for citeType, table := range v.AllSettings() {
+		keys, ok := table.(map[string]interface{})
+		if !ok {
+			if citeType != "extends" {
+				c.unknown = append(c.unknown, &ConfigError{source, citeType, "unknown key, want extends or a citation type table"})
+			}
+			continue
+		}
+		tc, ok := c.Types[citeType]
+		if !ok {
+			tc = newTypeConfig()
+			c.Types[citeType] = tc
+		}
+		for key := range keys {
+			switch {
+			case key == "inherits":
+				tc.Inherits = v.GetString(citeType + "." + key)
+				tc.origins[key] = source
+			case isRuleKey(key):
+				_, tc.replaced[key] = tc.Rules[key]
+				tc.Rules[key] = v.GetStringSlice(citeType + "." + key)
+				tc.origins[key] = source
+			default:
+				c.unknown = append(c.unknown, &ConfigError{source, citeType + "." + key, "unknown filter kind, want todos, optionals, inherits, or oneof_*"})
+			}
+		}
+	}
+	c.Sources = append(c.Sources, source)
+}
+
+func isRuleKey(key string) bool {
+	return key == "todos" || key == "optionals" || strings.HasPrefix(key, "oneof_")
+}
+
+// Resolve returns a new Config with the `inherits` of each type applied
+func (c *Config) Resolve() (*Config, error) {
+	resolved := NewConfig()
+	resolved.Sources = append(resolved.Sources, c.Sources...)
+	for citeType := range c.Types {
+		if _, err := c.resolveType(resolved, citeType, nil); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+func (c *Config) resolveType(resolved *Config, citeType string, chain []string) (*TypeConfig, error) {
+	if tc, ok := resolved.Types[citeType]; ok {
+		return tc, nil
+	}
+	for _, t := range chain {
+		if t == citeType {
+			return nil, fmt.Errorf("config: circular inherits %v", strings.Join(append(chain, citeType), " -> "))
+		}
+	}
+	tc, ok := c.Types[citeType]
+	if !ok {
+		child := chain[len(chain)-1]
+		return nil, &ConfigError{c.Types[child].origins["inherits"], child + ".inherits", fmt.Sprintf("unknown type %q", citeType)}
+	}
+
+	merged := newTypeConfig()
+	if tc.Inherits != "" {
+		parent, err := c.resolveType(resolved, tc.Inherits, append(chain, citeType))
+		if err != nil {
+			return nil, err
+		}
+		for key, fields := range parent.Rules {
+			merged.Rules[key] = fields
+			merged.origins[key] = parent.origins[key]
+		}
+		merged.origins["inherits"] = tc.origins["inherits"]
+	}
+	for key, fields := range tc.Rules {
+		merged.Rules[key] = fields
+		merged.origins[key] = tc.origins[key]
+	}
+	resolved.Types[citeType] = merged
+	return merged, nil
+}
+
+// ConfigError is a problem found in a config
+type ConfigError struct {
+	File string
+	Key  string
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config: %s: %s: %s", e.File, e.Key, e.Msg)
+}
+
+// ConfigErrors are the problems found in a config
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks the config for unknown keys and fields, empty oneof_ groups,
+// and fields in both todos and optionals of a type
+func (c *Config) Validate() ConfigErrors {
+	errs := append(ConfigErrors{}, c.unknown...)
+
+	for citeType, tc := range c.Types {
+		for key, fields := range tc.Rules {
+			origin := tc.origins[key]
+			for _, field := range fields {
+				if _, ok := bibItemBibtexIndex[field]; !ok {
+					errs = append(errs, &ConfigError{origin, citeType + "." + key, fmt.Sprintf("unknown field %q", field)})
+				}
+			}
+			// an empty group is allowed only to remove the one from the base or the parent
+			if strings.HasPrefix(key, "oneof_") && len(fields) == 0 && !tc.replaced[key] && !c.inheritsRule(tc, key) {
+				errs = append(errs, &ConfigError{origin, citeType + "." + key, "empty oneof group"})
+			}
+		}
+	}
+
+	resolved, err := c.Resolve()
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			errs = append(errs, configErr)
+		} else {
+			errs = append(errs, &ConfigError{"", "", err.Error()})
+		}
+	} else {
+		for citeType, tc := range resolved.Types {
+			for _, field := range tc.Rules["optionals"] {
+				if Filter(tc.Rules).HasField("todos", field) {
+					errs = append(errs, &ConfigError{tc.origins["optionals"], citeType + ".optionals", fmt.Sprintf("%q is also in todos", field)})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		if errs[i].Key != errs[j].Key {
+			return errs[i].Key < errs[j].Key
+		}
+		return errs[i].Msg < errs[j].Msg
+	})
+	return errs
+}
+
+// inheritsRule checks if the type inherits the rule key from its parents
+func (c *Config) inheritsRule(tc *TypeConfig, key string) bool {
+	seen := make(map[string]bool)
+	for tc.Inherits != "" && !seen[tc.Inherits] {
+		seen[tc.Inherits] = true
+		parent, ok := c.Types[tc.Inherits]
+		if !ok {
+			return false
+		}
+		if _, ok := parent.Rules[key]; ok {
+			return true
+		}
+		tc = parent
+	}
+	return false
+}
+
+// Filters returns the todos and optionals of each type as Filters
+func (c *Config) Filters() Filters {
+	filters := make(Filters)
+	for citeType, tc := range c.Types {
+		filter := NewFilter()
+		for _, key := range []string{"todos", "optionals"} {
+			if fields, ok := tc.Rules[key]; ok {
+				filter[key] = fields
+			}
+		}
+		filters[citeType] = filter
+	}
+	return filters
+}
+
+// Oneofs returns the oneof_ rules of each type as Oneofs
+func (c *Config) Oneofs() Oneofs {
+	oneofs := make(Oneofs)
+	for citeType, tc := range c.Types {
+		for _, key := range tc.sortedKeys() {
+			if !strings.HasPrefix(key, "oneof_") || len(tc.Rules[key]) == 0 {
+				continue
+			}
+			if !oneofs.HasOneof(citeType) {
+				oneofs[citeType] = NewOneof()
+			}
+			oneofs[citeType].AddOneof(tc.Rules[key])
+		}
+	}
+	return oneofs
+}
+
+// sortedKeys returns the rule keys in the order of todos, optionals, and oneof_*
+func (tc *TypeConfig) sortedKeys() []string {
+	keys := make([]string, 0, len(tc.Rules))
+	for key := range tc.Rules {
+		keys = append(keys, key)
+	}
+	rank := map[string]int{"todos": -2, "optionals": -1}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank[keys[i]], rank[keys[j]]
+		return ri < rj || (ri == rj && keys[i] < keys[j])
+	})
+	return keys
+}
+
+// String returns the config in TOML
+func (c *Config) String() string {
+	citeTypes := make([]string, 0, len(c.Types))
+	for citeType := range c.Types {
+		citeTypes = append(citeTypes, citeType)
+	}
+	sort.Strings(citeTypes)
+
+	var sb strings.Builder
+	for i, citeType := range citeTypes {
+		if i != 0 {
+			sb.WriteString("\n")
+		}
+		tc := c.Types[citeType]
+		sb.WriteString(fmt.Sprintf("[%s]\n", citeType))
+		if tc.Inherits != "" {
+			sb.WriteString(fmt.Sprintf("inherits = %q\n", tc.Inherits))
+		}
+		for _, key := range tc.sortedKeys() {
+			quoted := make([]string, len(tc.Rules[key]))
+			for j, field := range tc.Rules[key] {
+				quoted[j] = fmt.Sprintf("%q", field)
+			}
+			sb.WriteString(fmt.Sprintf("%s = [%s]\n", key, strings.Join(quoted, ", ")))
+		}
+	}
+	return sb.String()
+}
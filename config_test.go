@@ -0,0 +1,249 @@
+package bibfuse
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes the files to a temporary directory and returns the directory
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.toml": `
+[article]
+todos = ["author", "title"]
+optionals = ["doi", "url"]
+oneof_doi_url = ["doi", "url"]
+
+[default]
+todos = ["title"]
+`,
+		"paper.toml": `
+extends = ["base.toml"]
+
+[article]
+optionals = ["doi"]
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "paper.toml"))
+	if err != nil {
+		t.Fatalf("LoadConfig() err => %v, want nil", err)
+	}
+	if len(config.Sources) != 2 || filepath.Base(config.Sources[0]) != "base.toml" {
+		t.Errorf("config.Sources => %v, want [base.toml paper.toml]", config.Sources)
+	}
+
+	filters := config.Filters()
+	want := Filter{"todos": {"author", "title"}, "optionals": {"doi"}}
+	if !reflect.DeepEqual(filters["article"], want) {
+		t.Errorf("filters[article] => %v, want %v", filters["article"], want)
+	}
+	if origin := config.Types["article"].Origin("optionals"); filepath.Base(origin) != "paper.toml" {
+		t.Errorf("Origin(optionals) => %v, want paper.toml", origin)
+	}
+	oneofs := config.Oneofs()
+	if !reflect.DeepEqual(oneofs["article"], &Oneof{{"doi", "url"}}) {
+		t.Errorf("oneofs[article] => %v, want %v", oneofs["article"], &Oneof{{"doi", "url"}})
+	}
+}
+
+func TestLoadConfigCircularExtends(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.toml": "extends = [\"b.toml\"]\n",
+		"b.toml": "extends = [\"a.toml\"]\n",
+	})
+	_, err := LoadConfig(filepath.Join(dir, "a.toml"))
+	if err == nil || !strings.Contains(err.Error(), "circular extends") {
+		t.Errorf("LoadConfig() err => %v, want circular extends", err)
+	}
+}
+
+var inheritstests = []struct {
+	in   string
+	err  string
+	want Filters
+}{
+	{
+		`
+[default]
+todos = ["title"]
+
+[article]
+inherits = "default"
+optionals = ["doi"]
+
+[inproceedings]
+inherits = "article"
+todos = ["title", "booktitle"]
+`,
+		"",
+		Filters{
+			"default":       Filter{"todos": {"title"}},
+			"article":       Filter{"todos": {"title"}, "optionals": {"doi"}},
+			"inproceedings": Filter{"todos": {"title", "booktitle"}, "optionals": {"doi"}},
+		},
+	},
+	{
+		`
+[article]
+inherits = "book"
+`,
+		"article.inherits: unknown type \"book\"",
+		nil,
+	},
+	{
+		`
+[article]
+inherits = "book"
+
+[book]
+inherits = "article"
+`,
+		"circular inherits",
+		nil,
+	},
+}
+
+func TestConfigResolve(t *testing.T) {
+	for _, tt := range inheritstests {
+		dir := writeConfigFiles(t, map[string]string{"bibfuse.toml": tt.in})
+		config, err := LoadConfig(filepath.Join(dir, "bibfuse.toml"))
+		if err != nil {
+			t.Fatalf("LoadConfig() err => %v, want nil", err)
+		}
+		resolved, err := config.Resolve()
+		if err != nil {
+			if tt.err == "" || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Resolve() err => %v, want %v", err, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("Resolve() err => nil, want %v", tt.err)
+			continue
+		}
+		if filters := resolved.Filters(); !reflect.DeepEqual(filters, tt.want) {
+			t.Errorf("Resolve().Filters() => %v, want %v", filters, tt.want)
+		}
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	config := NewConfig()
+	tc := newTypeConfig()
+	tc.Rules["oneof_doi_url"] = []string{"doi", "url"}
+	tc.Rules["optionals"] = []string{"url"}
+	tc.Rules["todos"] = []string{"title"}
+	config.Types["misc"] = tc
+
+	want := `[misc]
+todos = ["title"]
+optionals = ["url"]
+oneof_doi_url = ["doi", "url"]
+`
+	if got := config.String(); got != want {
+		t.Errorf("Config.String() => \n%v, want \n%v", got, want)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	filters := DefaultFilters()
+	if !reflect.DeepEqual(filters["default"]["todos"], []string{"title"}) {
+		t.Errorf("DefaultFilters()[default] => %v, want todos [title]", filters["default"])
+	}
+	if !filters["article"].HasField("todos", "journal") {
+		t.Errorf("DefaultFilters()[article] => %v, want journal in todos", filters["article"])
+	}
+
+	oneofs := DefaultOneofs()
+	if !oneofs.HasOneof("article") || len(*oneofs["article"]) != 7 {
+		t.Errorf("DefaultOneofs()[article] => %v, want 7 oneofs", oneofs["article"])
+	}
+
+	if got := DefaultConfig().Sources; !reflect.DeepEqual(got, []string{DefaultConfigSource}) {
+		t.Errorf("DefaultConfig().Sources => %v, want %v", got, []string{DefaultConfigSource})
+	}
+}
+
+var validatetests = []struct {
+	in   map[string]string
+	want []string
+}{
+	{
+		map[string]string{"bibfuse.toml": `
+[article]
+optional = ["doi"]
+oneof-doi = ["doi", "url"]
+todos = ["title", "jornal"]
+oneof_doi_url = []
+`},
+		[]string{
+			"bibfuse.toml: article.oneof-doi: unknown filter kind",
+			"bibfuse.toml: article.oneof_doi_url: empty oneof group",
+			"bibfuse.toml: article.optional: unknown filter kind",
+			"bibfuse.toml: article.todos: unknown field \"jornal\"",
+		},
+	},
+	{
+		map[string]string{
+			"base.toml": `
+[article]
+todos = ["title", "year"]
+oneof_doi_url = ["doi", "url"]
+`,
+			"bibfuse.toml": `
+extends = ["base.toml"]
+colour = "red"
+
+[article]
+optionals = ["url", "year"]
+oneof_doi_url = []
+
+[inproceedings]
+inherits = "article"
+oneof_doi_url = []
+`},
+		[]string{
+			"bibfuse.toml: article.optionals: \"year\" is also in todos",
+			"bibfuse.toml: colour: unknown key",
+			"bibfuse.toml: inproceedings.optionals: \"year\" is also in todos",
+		},
+	},
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tt := range validatetests {
+		dir := writeConfigFiles(t, tt.in)
+		config, err := LoadConfig(filepath.Join(dir, "bibfuse.toml"))
+		if err != nil {
+			t.Fatalf("LoadConfig() err => %v, want nil", err)
+		}
+		errs := config.Validate()
+		if len(errs) != len(tt.want) {
+			t.Errorf("Validate() => %v, want %v", errs, tt.want)
+			continue
+		}
+		for i, e := range errs {
+			if !strings.Contains(e.Error(), tt.want[i]) {
+				t.Errorf("Validate()[%d] => %v, want %v", i, e, tt.want[i])
+			}
+		}
+	}
+
+	if errs := DefaultConfig().Validate(); len(errs) != 0 {
+		t.Errorf("DefaultConfig().Validate() => %v, want none", errs)
+	}
+}
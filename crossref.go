@@ -0,0 +1,94 @@
+package bibfuse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickng/bibtex"
+)
+
+// crossrefFields are the fields referring to the parents of an entry
+var crossrefFields = []string{"crossref", "xdata"}
+
+// crossrefTitles maps the parent types whose title becomes the booktitle of the children
+var crossrefTitles = map[string]bool{
+	"book":          true,
+	"collection":    true,
+	"mvbook":        true,
+	"mvcollection":  true,
+	"mvproceedings": true,
+	"proceedings":   true,
+}
+
+// noInherit are the fields never copied from the parents
+var noInherit = map[string]bool{
+	"crossref": true,
+	"xdata":    true,
+	"ids":      true,
+	"title":    true,
+}
+
+// InheritCrossref copies the fields missing in the entry from its crossref and xdata
+// parents found by lookup, so that the TODO filter sees the inherited fields
+func InheritCrossref(entry *bibtex.BibEntry, lookup func(citeName string) (*bibtex.BibEntry, bool)) error {
+	return inheritCrossref(entry, lookup, map[string]bool{entry.CiteName: true})
+}
+
+func inheritCrossref(entry *bibtex.BibEntry, lookup func(string) (*bibtex.BibEntry, bool), seen map[string]bool) error {
+	for _, refField := range crossrefFields {
+		ref, ok := entry.Fields[refField]
+		if !ok {
+			continue
+		}
+		// xdata can refer to multiple parents separated by commas
+		for _, parentName := range strings.Split(ref.String(), ",") {
+			parentName = strings.TrimSpace(parentName)
+			if parentName == "" {
+				continue
+			}
+			if seen[parentName] {
+				return &BuildError{CiteName: entry.CiteName, Err: fmt.Errorf("circular %v %v", refField, parentName)}
+			}
+			parent, ok := lookup(parentName)
+			if !ok {
+				return &BuildError{CiteName: entry.CiteName, Err: fmt.Errorf("%v %v not found", refField, parentName)}
+			}
+			seen[parentName] = true
+			if err := inheritCrossref(parent, lookup, seen); err != nil {
+				return err
+			}
+			delete(seen, parentName)
+
+			for name, value := range parent.Fields {
+				if noInherit[name] || isPlaceholder(value.String()) {
+					continue
+				}
+				if _, ok := entry.Fields[name]; !ok {
+					entry.AddField(name, value)
+				}
+			}
+			if title, ok := parent.Fields["title"]; ok && refField == "crossref" && crossrefTitles[parent.Type] {
+				if _, ok := entry.Fields["booktitle"]; !ok && !isPlaceholder(title.String()) {
+					entry.AddField("booktitle", title)
+				}
+			}
+		}
+		delete(entry.Fields, refField)
+	}
+	return nil
+}
+
+// isPlaceholder checks if the value is empty or filled by the filters
+func isPlaceholder(value string) bool {
+	return value == "" || value == "(TODO)" || value == "(OPTIONAL)"
+}
+
+// CollapseStringVars replaces the values referring to a string variable with `#name#`
+// as JabRef does, instead of the value of the variable
+func CollapseStringVars(entry *bibtex.BibEntry) {
+	for name, value := range entry.Fields {
+		if v, ok := value.(*bibtex.BibVar); ok {
+			entry.Fields[name] = bibtex.NewBibConst("#" + v.Key + "#")
+		}
+	}
+}
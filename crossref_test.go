@@ -0,0 +1,92 @@
+package bibfuse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickng/bibtex"
+)
+
+// lookupEntries returns a lookup of the entries in bib
+func lookupEntries(bib *bibtex.BibTex) func(string) (*bibtex.BibEntry, bool) {
+	entries := make(map[string]*bibtex.BibEntry)
+	for _, entry := range bib.Entries {
+		entries[entry.CiteName] = entry
+	}
+	return func(citeName string) (*bibtex.BibEntry, bool) {
+		entry, ok := entries[citeName]
+		return entry, ok
+	}
+}
+
+var crossreftests = []struct {
+	citeName string
+	field    string
+	want     string
+}{
+	{"child", "booktitle", "Proceedings of Something"},
+	{"child", "year", "2020"},
+	{"child", "publisher", "ACM"},
+	{"child", "title", "Child"},
+	{"child", "pages", "1--10"},
+	{"data", "publisher", "ACM"},
+	{"data", "address", "New York"},
+}
+
+func TestInheritCrossref(t *testing.T) {
+	src := `@proceedings{conf,
+  title = {Proceedings of Something},
+  year = {2020},
+  publisher = {ACM},
+  pages = {(TODO)},
+}
+@xdata{acm, publisher = {ACM}, address = {New York}}
+@inproceedings{child,
+  title = {Child},
+  pages = {1--10},
+  crossref = {conf},
+}
+@book{data, title = {Data}, xdata = {acm}}
+`
+	bib, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Parse() err => %v, want nil", err)
+	}
+	lookup := lookupEntries(bib)
+	for _, tt := range crossreftests {
+		entry, _ := lookup(tt.citeName)
+		if err := InheritCrossref(entry, lookup); err != nil {
+			t.Fatalf("InheritCrossref(%v) err => %v, want nil", tt.citeName, err)
+		}
+		if got, ok := entry.Fields[tt.field]; !ok || got.String() != tt.want {
+			t.Errorf("InheritCrossref(%v) %v => %v, want %v", tt.citeName, tt.field, got, tt.want)
+		}
+		if _, ok := entry.Fields["crossref"]; ok {
+			t.Errorf("InheritCrossref(%v) => crossref kept, want removed", tt.citeName)
+		}
+	}
+
+	bib, _ = Parse(strings.NewReader("@misc{a, crossref = {b}}\n@misc{b, crossref = {a}}\n@misc{c, crossref = {d}}\n"))
+	lookup = lookupEntries(bib)
+	if err := InheritCrossref(bib.Entries[0], lookup); err == nil || !strings.Contains(err.Error(), "circular crossref") {
+		t.Errorf("InheritCrossref(a) err => %v, want circular crossref", err)
+	}
+	if err := InheritCrossref(bib.Entries[2], lookup); err == nil || !strings.Contains(err.Error(), "crossref d not found") {
+		t.Errorf("InheritCrossref(c) err => %v, want crossref d not found", err)
+	}
+}
+
+func TestCollapseStringVars(t *testing.T) {
+	bib, err := Parse(strings.NewReader("@string{ieee = \"IEEE\"}\n@article{a, journal = ieee, title = {A}}\n"))
+	if err != nil {
+		t.Fatalf("Parse() err => %v, want nil", err)
+	}
+	entry := bib.Entries[0]
+	CollapseStringVars(entry)
+	if journal := entry.Fields["journal"].String(); journal != "#ieee#" {
+		t.Errorf("CollapseStringVars() journal => %v, want #ieee#", journal)
+	}
+	if title := entry.Fields["title"].String(); title != "A" {
+		t.Errorf("CollapseStringVars() title => %v, want A", title)
+	}
+}
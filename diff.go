@@ -0,0 +1,125 @@
+package bibfuse
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// the statuses of the entries in a diff
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffRenamed = "renamed"
+	DiffChanged = "changed"
+)
+
+// FieldChange is a change of a field value, a placeholder is an empty value
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// EntryDiff is a change of an entry between two bibliographies
+type EntryDiff struct {
+	Status  string        `json:"status"`
+	Key     string        `json:"key"`               // the cite name in the new one, or in the old one if removed
+	OldKey  string        `json:"old_key,omitempty"` // the cite name in the old one if renamed
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// DiffBibliographies compares the entries by the cite names, the entries removed and added with
+// the same DOI or title are renamed, and the diffs are in the order of the cite names
+func DiffBibliographies(old, new []BibItem) []EntryDiff {
+	oldItems, newItems := indexBibItems(old), indexBibItems(new)
+	var diffs []EntryDiff
+	var added []BibItem
+	for _, bi := range new {
+		if _, ok := oldItems[bi.CiteName]; !ok {
+			added = append(added, bi)
+		}
+	}
+
+	renamed := make(map[string]bool) // the cite names of the added entries renamed
+	for _, bi := range old {
+		if _, ok := newItems[bi.CiteName]; ok {
+			if changes := diffFieldValues(bi, newItems[bi.CiteName]); len(changes) > 0 {
+				diffs = append(diffs, EntryDiff{Status: DiffChanged, Key: bi.CiteName, Changes: changes})
+			}
+			continue
+		}
+		if renamedTo, ok := findRenamed(bi, added, renamed); ok {
+			renamed[renamedTo.CiteName] = true
+			diffs = append(diffs, EntryDiff{Status: DiffRenamed, Key: renamedTo.CiteName, OldKey: bi.CiteName, Changes: diffFieldValues(bi, renamedTo)})
+			continue
+		}
+		diffs = append(diffs, EntryDiff{Status: DiffRemoved, Key: bi.CiteName})
+	}
+	for _, bi := range added {
+		if !renamed[bi.CiteName] {
+			diffs = append(diffs, EntryDiff{Status: DiffAdded, Key: bi.CiteName})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+// findRenamed returns the entry added with the DOI of bi, or else with its title
+func findRenamed(bi BibItem, added []BibItem, renamed map[string]bool) (BibItem, bool) {
+	for _, same := range []func(a, b BibItem) bool{
+		func(a, b BibItem) bool {
+			return !isPlaceholder(a.DOI) && strings.EqualFold(a.DOI, b.DOI)
+		},
+		func(a, b BibItem) bool {
+			title := normalizeTitle(a.Title)
+			return title != "" && title == normalizeTitle(b.Title)
+		},
+	} {
+		for _, candidate := range added {
+			if !renamed[candidate.CiteName] && same(bi, candidate) {
+				return candidate, true
+			}
+		}
+	}
+	return BibItem{}, false
+}
+
+// normalizeTitle returns the letters and digits of the title in lowercase
+func normalizeTitle(title string) string {
+	if isPlaceholder(title) {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		if isWordRune(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, title)
+}
+
+// diffFieldValues returns the changes of the fields other than the cite name
+func diffFieldValues(old, new BibItem) []FieldChange {
+	vOld, vNew := reflect.ValueOf(old), reflect.ValueOf(new)
+	var changes []FieldChange
+	for _, meta := range bibItemFieldMetas {
+		if !meta.hasBibtex || meta.bibtexName == "cite_name" {
+			continue
+		}
+		a, b := meta.valueFrom(vOld), meta.valueFrom(vNew)
+		if isPlaceholder(a) {
+			a = ""
+		}
+		if isPlaceholder(b) {
+			b = ""
+		}
+		if a != b {
+			changes = append(changes, FieldChange{Field: meta.bibtexName, Old: a, New: b})
+		}
+	}
+	return changes
+}
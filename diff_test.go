@@ -0,0 +1,34 @@
+package bibfuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffBibliographies(t *testing.T) {
+	old := []BibItem{
+		{CiteName: "a", CiteType: "misc", Title: "A", Year: "2019"},
+		{CiteName: "b", CiteType: "misc", Title: "{B}eta", DOI: "(OPTIONAL)"},
+		{CiteName: "c", CiteType: "misc", Title: "C", DOI: "10.1000/C"},
+		{CiteName: "d", CiteType: "misc", Title: "D", Note: "(TODO)"},
+	}
+	new := []BibItem{
+		{CiteName: "a", CiteType: "misc", Title: "A", Year: "2020"},
+		{CiteName: "beta", CiteType: "misc", Title: "beta", DOI: "(TODO)"},
+		{CiteName: "c2", CiteType: "misc", Title: "C Renamed", DOI: "10.1000/c"},
+		{CiteName: "d", CiteType: "misc", Title: "D", Note: "(OPTIONAL)"},
+		{CiteName: "e", CiteType: "misc", Title: "E"},
+	}
+	want := []EntryDiff{
+		{Status: DiffChanged, Key: "a", Changes: []FieldChange{{Field: "year", Old: "2019", New: "2020"}}},
+		{Status: DiffRenamed, Key: "beta", OldKey: "b", Changes: []FieldChange{{Field: "title", Old: "{B}eta", New: "beta"}}},
+		{Status: DiffRenamed, Key: "c2", OldKey: "c", Changes: []FieldChange{{Field: "title", Old: "C", New: "C Renamed"}, {Field: "doi", Old: "10.1000/C", New: "10.1000/c"}}},
+		{Status: DiffAdded, Key: "e"},
+	}
+	if got := DiffBibliographies(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffBibliographies() => %+v, want %+v", got, want)
+	}
+	if got := DiffBibliographies(old, old[:1]); len(got) != 3 || got[0].Status != DiffRemoved {
+		t.Errorf("DiffBibliographies() => %+v, want 3 removed", got)
+	}
+}
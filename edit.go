@@ -0,0 +1,189 @@
+package bibfuse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// stringRefRE matches a value referring to a string variable collapsed by CollapseStringVars
+var stringRefRE = regexp.MustCompile(`^#([^#"\s]+)#$`)
+
+// blockField is the position of a field in the text of a block
+type blockField struct {
+	name       string
+	start      int // the offset of the name
+	valueStart int
+	end        int // the offset after the value
+}
+
+// scanBlockFields returns the fields in the text of an entry block and the offset of its closing brace
+func scanBlockFields(text string) ([]blockField, int, error) {
+	open := strings.IndexAny(text, "{(")
+	if open < 0 {
+		return nil, 0, fmt.Errorf("no opening brace")
+	}
+	closing := byte('}')
+	if text[open] == '(' {
+		closing = ')'
+	}
+	i := strings.IndexAny(text[open:], ",})")
+	if i < 0 {
+		return nil, 0, fmt.Errorf("no closing brace")
+	}
+
+	var fields []blockField
+	for i += open; i < len(text); {
+		switch c := text[i]; {
+		case c == ',' || c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == closing:
+			return fields, i, nil
+		}
+
+		f := blockField{start: i}
+		for i < len(text) && isBareChar(text[i]) {
+			i++
+		}
+		f.name = strings.ToLower(text[f.start:i])
+		i = skipSpaces(text, i)
+		if f.name == "" || i >= len(text) || text[i] != '=' {
+			return nil, 0, fmt.Errorf("malformed field at offset %d", f.start)
+		}
+		f.valueStart = skipSpaces(text, i+1)
+		for i = f.valueStart; i < len(text); {
+			switch {
+			case text[i] == '{' || text[i] == '"':
+				i = skipDelimited(text, i)
+			case isBareChar(text[i]):
+				for i < len(text) && isBareChar(text[i]) {
+					i++
+				}
+			default:
+				return nil, 0, fmt.Errorf("malformed value of %s", f.name)
+			}
+			f.end = i
+			// the parts of a value are concatenated with #
+			if j := skipSpaces(text, i); j < len(text) && text[j] == '#' {
+				i = skipSpaces(text, j+1)
+				continue
+			}
+			break
+		}
+		fields = append(fields, f)
+	}
+	return nil, 0, fmt.Errorf("no closing brace")
+}
+
+func skipSpaces(text string, i int) int {
+	for i < len(text) && strings.IndexByte(" \t\r\n", text[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// formatBlockValue returns the value as written in a block with the delimiters of the previous
+// value, a string variable is bare and a new value is braced
+func formatBlockValue(value, previous string) string {
+	if m := stringRefRE.FindStringSubmatch(value); m != nil {
+		return m[1]
+	}
+	switch {
+	case strings.HasPrefix(previous, "\"") && !strings.ContainsAny(value, "\"{}"):
+		return "\"" + value + "\""
+	case previous != "" && isBareChar(previous[0]) && value != "" && strings.Trim(value, "0123456789") == "":
+		return value
+	}
+	return "{" + value + "}"
+}
+
+// SetBlockField sets the value of the field in the text of an entry block, keeping the rest
+// of the text as it is, a new field is added after the last one with the same indentation
+func SetBlockField(text, name, value string) (string, error) {
+	return replaceBlockField(text, name, func(previous string) string {
+		return formatBlockValue(value, previous)
+	})
+}
+
+// replaceBlockField sets the field to the value written by format from the previous one,
+// which is empty for a new field
+func replaceBlockField(text, name string, format func(previous string) string) (string, error) {
+	fields, closing, err := scanBlockFields(text)
+	if err != nil {
+		return text, err
+	}
+	for _, f := range fields {
+		if f.name == name {
+			return text[:f.valueStart] + format(text[f.valueStart:f.end]) + text[f.end:], nil
+		}
+	}
+	formatted := format("")
+
+	if len(fields) == 0 {
+		// put the field after the cite name
+		key := strings.IndexAny(text, ",})")
+		field := "\n    " + name + " = " + formatted + ",\n"
+		if text[key] == ',' {
+			return text[:key+1] + field + text[key+1:], nil
+		}
+		return text[:key] + "," + field + text[key:], nil
+	}
+
+	last := fields[len(fields)-1]
+	indent := last.start - (strings.LastIndexByte(text[:last.start], '\n') + 1)
+	field := "\n" + text[last.start-indent:last.start] + name + " = " + formatted
+	if j := skipSpaces(text, last.end); j < closing && text[j] == ',' {
+		return text[:j+1] + field + "," + text[j+1:], nil
+	}
+	return text[:last.end] + "," + field + text[last.end:], nil
+}
+
+// RemoveBlockField removes the field from the text of an entry block, with its line
+// if nothing else is on it
+func RemoveBlockField(text, name string) (string, error) {
+	fields, _, err := scanBlockFields(text)
+	if err != nil {
+		return text, err
+	}
+	for _, f := range fields {
+		if f.name != name {
+			continue
+		}
+		start, end := f.start, f.end
+		if j := skipSpaces(text, end); j < len(text) && text[j] == ',' {
+			end = j + 1
+		}
+		lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+		if strings.TrimSpace(text[lineStart:start]) == "" {
+			rest := text[end:]
+			if k := strings.IndexByte(rest, '\n'); k >= 0 && strings.TrimSpace(rest[:k]) == "" {
+				start, end = lineStart, end+k+1
+			}
+		}
+		return text[:start] + text[end:], nil
+	}
+	return text, nil
+}
+
+// HasConcatenation checks if a value in the text of an entry block is concatenated with #,
+// of which bibtex.Parse keeps only the first part
+func HasConcatenation(text string) bool {
+	fields, _, err := scanBlockFields(text)
+	if err != nil {
+		return false
+	}
+	for _, f := range fields {
+		for i := f.valueStart; i < f.end; {
+			switch c := text[i]; {
+			case c == '{' || c == '"':
+				i = skipDelimited(text, i)
+			case c == '#':
+				return true
+			default:
+				i++
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package bibfuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+const editedBlock = `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  author = "Mizutani, Iori" # { and others},
+  year   = 2019
+}`
+
+var setblockfieldtests = []struct {
+	name  string
+	value string
+	want  string
+}{
+	{"title", "A Reader", `@article{mizutani2019,
+  title  = {A Reader},
+  author = "Mizutani, Iori" # { and others},
+  year   = 2019
+}`},
+	{"author", "Mizutani, Iori", `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  author = "Mizutani, Iori",
+  year   = 2019
+}`},
+	{"doi", "10.1000/1", `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  author = "Mizutani, Iori" # { and others},
+  year   = 2019,
+  doi = {10.1000/1}
+}`},
+	{"journal", "#ieee#", `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  author = "Mizutani, Iori" # { and others},
+  year   = 2019,
+  journal = ieee
+}`},
+	{"year", "2020", `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  author = "Mizutani, Iori" # { and others},
+  year   = 2020
+}`},
+}
+
+func TestSetBlockField(t *testing.T) {
+	for _, tt := range setblockfieldtests {
+		got, err := SetBlockField(editedBlock, tt.name, tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("SetBlockField(%v, %v) => %v, %v, want %v", tt.name, tt.value, got, err, tt.want)
+		}
+	}
+
+	got, err := SetBlockField("@misc{key,\n  note = {a},\n}", "year", "2021")
+	if want := "@misc{key,\n  note = {a},\n  year = {2021},\n}"; err != nil || got != want {
+		t.Errorf("SetBlockField() with a trailing comma => %v, %v, want %v", got, err, want)
+	}
+	got, err = SetBlockField("@misc{key}", "year", "2021")
+	if want := "@misc{key,\n    year = {2021},\n}"; err != nil || got != want {
+		t.Errorf("SetBlockField() without fields => %v, %v, want %v", got, err, want)
+	}
+	if _, err := SetBlockField("@misc{key, note {a}}", "year", "2021"); err == nil {
+		t.Errorf("SetBlockField() of a malformed block => nil, want an error")
+	}
+}
+
+func TestRemoveBlockField(t *testing.T) {
+	got, err := RemoveBlockField(editedBlock, "author")
+	want := `@article{mizutani2019,
+  title  = {An {RFID} Reader},
+  year   = 2019
+}`
+	if err != nil || got != want {
+		t.Errorf("RemoveBlockField(author) => %v, %v, want %v", got, err, want)
+	}
+	if got, err := RemoveBlockField(editedBlock, "doi"); err != nil || got != editedBlock {
+		t.Errorf("RemoveBlockField(doi) => %v, %v, want the block as it is", got, err)
+	}
+}
+
+func TestBlockKey(t *testing.T) {
+	var keys []string
+	for _, block := range SplitBlocks([]byte("@string{ieee = {IEEE}}\n@article{ mizutani2019,\n title = {A}}\n")) {
+		if block.Type != "" {
+			keys = append(keys, block.Key())
+		}
+	}
+	if !reflect.DeepEqual(keys, []string{"ieee", "mizutani2019"}) {
+		t.Errorf("Key() => %v, want [ieee mizutani2019]", keys)
+	}
+}
+
+func TestHasConcatenation(t *testing.T) {
+	for text, want := range map[string]bool{
+		"@misc{key,\n  note = \"Vol. \" # acm,\n}":  true,
+		"@misc{key,\n  note = acm#{ Press},\n}":     true,
+		"@misc{key,\n  note = {C\\# and \"#\"},\n}": false,
+		"@misc{key,\n  note = \"#1\", year = 2020}": false,
+	} {
+		if got := HasConcatenation(text); got != want {
+			t.Errorf("HasConcatenation(%q) => %v, want %v", text, got, want)
+		}
+	}
+}
@@ -3,7 +3,9 @@ module github.com/iomz/bibfuse
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.8
 	github.com/nickng/bibtex v1.0.3
 	github.com/spf13/viper v1.9.0
+	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,245 @@
+package bibfuse
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/nickng/bibtex"
+	"golang.org/x/text/unicode/norm"
+)
+
+// latexAccents maps the LaTeX accent commands to the Unicode combining characters
+var latexAccents = map[string]rune{
+	"`":  '\u0300',
+	"'":  '\u0301',
+	"^":  '\u0302',
+	"~":  '\u0303',
+	"=":  '\u0304',
+	"u":  '\u0306',
+	".":  '\u0307',
+	"\"": '\u0308',
+	"r":  '\u030a',
+	"H":  '\u030b',
+	"v":  '\u030c',
+	"d":  '\u0323',
+	"c":  '\u0327',
+	"k":  '\u0328',
+	"b":  '\u0331',
+}
+
+// latexSymbols maps the LaTeX commands for special letters to Unicode
+var latexSymbols = map[string]string{
+	"AA": "Å",
+	"AE": "Æ",
+	"L":  "Ł",
+	"O":  "Ø",
+	"OE": "Œ",
+	"aa": "å",
+	"ae": "æ",
+	"i":  "ı",
+	"j":  "ȷ",
+	"l":  "ł",
+	"o":  "ø",
+	"oe": "œ",
+	"ss": "ß",
+}
+
+var (
+	// unicodeAccents is the reverse of latexAccents
+	unicodeAccents = make(map[rune]string, len(latexAccents))
+	// unicodeSymbols is the reverse of latexSymbols, and the typographic characters
+	unicodeSymbols = map[rune]string{
+		'\u00a0': "~",
+		'\u2013': "--",
+		'\u2014': "---",
+		'\u2018': "`",
+		'\u2019': "'",
+		'\u201c': "``",
+		'\u201d': "''",
+	}
+)
+
+func init() {
+	for command, mark := range latexAccents {
+		unicodeAccents[mark] = command
+	}
+	for command, symbol := range latexSymbols {
+		unicodeSymbols[[]rune(symbol)[0]] = "{\\" + command + "}"
+	}
+}
+
+// unconvertedFields are the fields kept as they are (e.g., the backslashes in URLs are not LaTeX)
+var unconvertedFields = map[string]bool{
+	"cite_name": true,
+	"cite_type": true,
+	"doi":       true,
+	"url":       true,
+}
+
+// LaTeXToUnicode replaces the LaTeX accents and special letters (e.g., `M{\"u}ller`,
+// `M\"{u}ller`, or `{\ss}`) with the Unicode characters in NFC
+func LaTeXToUnicode(s string) string {
+	if !strings.Contains(s, "\\") {
+		return norm.NFC.String(s)
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if replaced, end, ok := parseLaTeXLetter(s, i); ok {
+			sb.WriteString(replaced)
+			i = end
+			continue
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return norm.NFC.String(sb.String())
+}
+
+// parseLaTeXLetter parses an accented or special letter at i, either
+// `{\cmd...}` or `\cmd...`, and returns it in Unicode with the offset after it
+func parseLaTeXLetter(s string, i int) (string, int, bool) {
+	if strings.HasPrefix(s[i:], "{\\") {
+		letter, end, ok := parseLaTeXCommand(s, i+1)
+		if ok && end < len(s) && s[end] == '}' {
+			return letter, end + 1, true
+		}
+		return "", 0, false
+	}
+	if s[i] == '\\' {
+		return parseLaTeXCommand(s, i)
+	}
+	return "", 0, false
+}
+
+// parseLaTeXCommand parses `\"u`, `\"{u}`, `\v{c}`, `\v c`, `\ss`, or `\ss{}` at i
+func parseLaTeXCommand(s string, i int) (string, int, bool) {
+	j := i + 1
+	if j >= len(s) {
+		return "", 0, false
+	}
+	var command string
+	if strings.IndexByte("`'^~=.\"", s[j]) >= 0 {
+		command = s[j : j+1]
+		j++
+	} else {
+		for j < len(s) && isASCIILetter(s[j]) {
+			j++
+		}
+		command = s[i+1 : j]
+	}
+
+	if symbol, ok := latexSymbols[command]; ok {
+		if strings.HasPrefix(s[j:], "{}") {
+			j += 2
+		} else if j < len(s) && s[j] == ' ' {
+			j++
+		}
+		return symbol, j, true
+	}
+
+	mark, ok := latexAccents[command]
+	if !ok {
+		return "", 0, false
+	}
+	if isASCIILetter(command[0]) {
+		// a space separates the command from an unbraced letter
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+	}
+	base, end, ok := parseLaTeXArg(s, j)
+	if !ok {
+		return "", 0, false
+	}
+	return base + string(mark), end, true
+}
+
+// parseLaTeXArg parses the letter accented, `u`, `{u}`, `\i`, or `{\i}` at i
+func parseLaTeXArg(s string, i int) (string, int, bool) {
+	braced := i < len(s) && s[i] == '{'
+	if braced {
+		i++
+	}
+	var base string
+	switch {
+	case i < len(s) && isASCIILetter(s[i]):
+		base = s[i : i+1]
+		i++
+	case strings.HasPrefix(s[i:], "\\i") || strings.HasPrefix(s[i:], "\\j"):
+		// the dotless letters are for the accents, which make them dotless anyway
+		base = s[i+1 : i+2]
+		i += 2
+		if !braced && i < len(s) && s[i] == ' ' {
+			i++
+		}
+	default:
+		return "", 0, false
+	}
+	if braced {
+		if i >= len(s) || s[i] != '}' {
+			return "", 0, false
+		}
+		i++
+	}
+	return base, i, true
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// UnicodeToLaTeX replaces the non-ASCII letters with the LaTeX accents and special letters
+// (e.g., `M{\"u}ller`) for the legacy BibTeX, the characters without one are kept as they are
+func UnicodeToLaTeX(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFC.String(s) {
+		if r <= unicode.MaxASCII {
+			sb.WriteRune(r)
+			continue
+		}
+		if symbol, ok := unicodeSymbols[r]; ok {
+			sb.WriteString(symbol)
+			continue
+		}
+		decomposed := []rune(norm.NFD.String(string(r)))
+		command, ok := "", false
+		if len(decomposed) == 2 && decomposed[0] <= unicode.MaxASCII {
+			command, ok = unicodeAccents[decomposed[1]]
+		}
+		if !ok {
+			sb.WriteRune(r)
+			continue
+		}
+		if isASCIILetter(command[0]) {
+			sb.WriteString("{\\" + command + "{" + string(decomposed[0]) + "}}")
+		} else {
+			sb.WriteString("{\\" + command + string(decomposed[0]) + "}")
+		}
+	}
+	return sb.String()
+}
+
+// ConvertFields replaces the field values of bi with convert (e.g., LaTeXToUnicode),
+// except the cite name, type, DOI, and URL
+func ConvertFields(bi *BibItem, convert func(string) string) {
+	for _, meta := range bibItemFieldMetas {
+		if !meta.hasBibtex || unconvertedFields[meta.bibtexName] {
+			continue
+		}
+		value, _ := bi.FieldValueByBibTexName(meta.bibtexName)
+		_ = bi.SetFieldByBibTexName(meta.bibtexName, convert(value))
+	}
+}
+
+// ConvertEntryFields is ConvertFields for a parsed entry, which has the values
+// of the string variables in place unless collapsed
+func ConvertEntryFields(entry *bibtex.BibEntry, convert func(string) string) {
+	for name, value := range entry.Fields {
+		if unconvertedFields[name] {
+			continue
+		}
+		if converted := convert(value.String()); converted != value.String() {
+			entry.Fields[name] = bibtex.NewBibConst(converted)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package bibfuse
+
+import (
+	"testing"
+
+	"github.com/nickng/bibtex"
+)
+
+var latextounicodetests = []struct {
+	in  string
+	out string
+}{
+	{`M{\"u}ller`, "Müller"},
+	{`M\"{u}ller`, "Müller"},
+	{`M\"uller`, "Müller"},
+	{"Müller", "Müller"},
+	{`Erd{\H{o}}s and \v Cech`, "Erdős and Čech"},
+	{`Gau{\ss} and {\O}rsted`, "Gauß and Ørsted"},
+	{`Stra\ss e`, "Straße"},
+	{`Na{\"\i}ve {\'e}t{\'e}`, "Naïve été"},
+	{`Fran\c{c}ois {\aa}ngstr\"om`, "François ångström"},
+	{`R\&D in \textbf{bold} 100\%`, `R\&D in \textbf{bold} 100\%`},
+}
+
+func TestLaTeXToUnicode(t *testing.T) {
+	for _, tt := range latextounicodetests {
+		if got := LaTeXToUnicode(tt.in); got != tt.out {
+			t.Errorf("LaTeXToUnicode(%q) => %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+var unicodetolatextests = []struct {
+	in  string
+	out string
+}{
+	{"Müller", `M{\"u}ller`},
+	{"Erdős and Čech", `Erd{\H{o}}s and {\v{C}}ech`},
+	{"Gauß and Ørsted", `Gau{\ss} and {\O}rsted`},
+	{"Naïve été", `Na{\"i}ve {\'e}t{\'e}`},
+	{"pp. 1–10", "pp. 1--10"},
+	{"東京", "東京"},
+}
+
+func TestUnicodeToLaTeX(t *testing.T) {
+	for _, tt := range unicodetolatextests {
+		if got := UnicodeToLaTeX(tt.in); got != tt.out {
+			t.Errorf("UnicodeToLaTeX(%q) => %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestConvertFields(t *testing.T) {
+	bi := NewBibItem()
+	bi.CiteName = `m\"uller2021`
+	bi.Author = `M{\"u}ller, J{\"o}rg`
+	bi.URL = `https://example.com/a\"b`
+	ConvertFields(&bi, LaTeXToUnicode)
+	if bi.Author != "Müller, Jörg" || bi.CiteName != `m\"uller2021` || bi.URL != `https://example.com/a\"b` {
+		t.Errorf("ConvertFields() => %v, %v, %v, want the author converted only", bi.CiteName, bi.Author, bi.URL)
+	}
+}
+
+func TestConvertEntryFields(t *testing.T) {
+	entry := bibtex.NewBibEntry("article", "a")
+	entry.AddField("author", bibtex.NewBibConst(`M{\"u}ller, J\"{o}rg`))
+	entry.AddField("doi", bibtex.NewBibConst(`10.1000/a\"b`))
+	ConvertEntryFields(entry, LaTeXToUnicode)
+	if author := entry.Fields["author"].String(); author != "Müller, Jörg" {
+		t.Errorf("ConvertEntryFields() author => %v, want Müller, Jörg", author)
+	}
+	if doi := entry.Fields["doi"].String(); doi != `10.1000/a\"b` {
+		t.Errorf("ConvertEntryFields() doi => %v, want unchanged", doi)
+	}
+}
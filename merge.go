@@ -0,0 +1,415 @@
+package bibfuse
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/nickng/bibtex"
+)
+
+// DiffFields returns the bibtex names of the fields differing between a and b
+func DiffFields(a, b BibItem) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var names []string
+	for _, meta := range bibItemFieldMetas {
+		if meta.hasBibtex && meta.valueFrom(va) != meta.valueFrom(vb) {
+			names = append(names, meta.bibtexName)
+		}
+	}
+	return names
+}
+
+// MergeBibItems merges the changes from base in ours and theirs field by field, and returns
+// the fields changed differently on both sides as the conflicts, which keep ours
+func MergeBibItems(base, ours, theirs BibItem) (BibItem, []string) {
+	merged := ours
+	vBase, vOurs, vTheirs := reflect.ValueOf(base), reflect.ValueOf(ours), reflect.ValueOf(theirs)
+	var conflicts []string
+	for _, meta := range bibItemFieldMetas {
+		b, o, t := meta.valueFrom(vBase), meta.valueFrom(vOurs), meta.valueFrom(vTheirs)
+		switch {
+		case o == t || t == b:
+		case o == b:
+			meta.setString(&merged, t)
+		default:
+			conflicts = append(conflicts, meta.bibtexName)
+		}
+	}
+	return merged, conflicts
+}
+
+// MergedBlock is a block of a bibtex source merged from the three versions of the source
+type MergedBlock struct {
+	Key       string   // the cite name of an entry, or empty for the other blocks
+	Ours      string   // the merged text with ours for the conflicts, or empty if deleted in ours
+	Theirs    string   // the merged text with theirs for the conflicts, or empty if deleted in theirs
+	Conflicts []string // the fields changed differently on both sides
+}
+
+// IsConflict checks if the block is changed differently on both sides,
+// or deleted on a side and changed on the other
+func (m MergedBlock) IsConflict() bool {
+	return m.Ours != m.Theirs
+}
+
+// blockIndex has the entries of a source by the lowercase cite name, the first one for the duplicates,
+// and the number of the other blocks by the text
+type blockIndex struct {
+	entries map[string]Block
+	texts   map[string]int
+}
+
+func indexBlocks(blocks []Block) blockIndex {
+	index := blockIndex{entries: make(map[string]Block), texts: make(map[string]int)}
+	for _, b := range blocks {
+		if key := entryKey(b); key != "" {
+			if _, ok := index.entries[key]; !ok {
+				index.entries[key] = b
+				continue
+			}
+		}
+		index.texts[strings.TrimSpace(b.Text)]++
+	}
+	return index
+}
+
+// isIndexed checks if the block is the entry indexed by the cite name rather than a duplicate or another block
+func (index blockIndex) isIndexed(b Block) bool {
+	key := entryKey(b)
+	return key != "" && index.entries[key].Offset == b.Offset
+}
+
+func entryKey(b Block) string {
+	if !b.IsEntry() {
+		return ""
+	}
+	return strings.ToLower(b.Key())
+}
+
+func sameText(a, b string) bool {
+	return strings.TrimSpace(a) == strings.TrimSpace(b)
+}
+
+// sourceIndex is a version of a bibtex source with the blocks indexed, and the entries parsed
+// by the lowercase cite name, without the ones failing to parse or with # concatenations
+type sourceIndex struct {
+	blockIndex
+	blocks []Block
+	parsed map[string]*bibtex.BibEntry
+}
+
+func indexSource(src []byte) sourceIndex {
+	blocks := SplitBlocks(src)
+	index := sourceIndex{blockIndex: indexBlocks(blocks), blocks: blocks, parsed: make(map[string]*bibtex.BibEntry)}
+	bib, errs := ParseTolerant(src, "")
+	failed := make(map[int]bool)
+	for _, err := range errs {
+		failed[err.Line] = true
+	}
+	for _, entry := range bib.Entries {
+		key := strings.ToLower(entry.CiteName)
+		b, ok := index.entries[key]
+		if _, dup := index.parsed[key]; !ok || dup || failed[b.Line] || HasConcatenation(b.Text) {
+			continue
+		}
+		index.parsed[key] = lowerFields(entry)
+	}
+	return index
+}
+
+// text returns the entry written by a Writer with opts if parsed, or as it is written
+func (index sourceIndex) text(key string, opts WriteOptions) string {
+	if entry, ok := index.parsed[key]; ok {
+		return writeEntry(entry, opts)
+	}
+	return strings.TrimSpace(index.entries[key].Text) + "\n"
+}
+
+// lowerFields returns a copy of the entry with the field names in lowercase
+func lowerFields(entry *bibtex.BibEntry) *bibtex.BibEntry {
+	copied := bibtex.NewBibEntry(entry.Type, entry.CiteName)
+	for name, value := range entry.Fields {
+		copied.AddField(strings.ToLower(name), value)
+	}
+	return copied
+}
+
+// writeEntry returns the entry written by a Writer with opts
+func writeEntry(entry *bibtex.BibEntry, opts WriteOptions) string {
+	var sb strings.Builder
+	NewWriter(&sb, opts).WriteEntry(entry)
+	return sb.String()
+}
+
+// MergeSources merges the three versions of a bibtex source, and returns the blocks other than the
+// entries (e.g., @preamble, @string, and the comments) of ours unless deleted in theirs and the ones
+// added in theirs, followed by the entries merged field by field in the order of the cite names,
+// separated by blank lines. The entries parsed in all the versions are written by a Writer with opts,
+// the others are merged with the values as they are written.
+func MergeSources(base, ours, theirs []byte, opts WriteOptions) []MergedBlock {
+	baseSrc, ourSrc, theirSrc := indexSource(base), indexSource(ours), indexSource(theirs)
+
+	var merged []MergedBlock
+	add := func(m MergedBlock) {
+		if len(merged) > 0 {
+			merged = append(merged, MergedBlock{Ours: "\n", Theirs: "\n"})
+		}
+		merged = append(merged, m)
+	}
+	for _, b := range ourSrc.blocks {
+		text := strings.TrimSpace(b.Text)
+		if ourSrc.isIndexed(b) || text == "" || baseSrc.texts[text] > 0 && theirSrc.texts[text] == 0 {
+			continue
+		}
+		add(MergedBlock{Ours: text + "\n", Theirs: text + "\n"})
+	}
+	for _, b := range theirSrc.blocks {
+		text := strings.TrimSpace(b.Text)
+		if theirSrc.isIndexed(b) || text == "" || baseSrc.texts[text] > 0 || ourSrc.texts[text] > 0 {
+			continue
+		}
+		add(MergedBlock{Ours: text + "\n", Theirs: text + "\n"})
+	}
+
+	var keys []string
+	for _, index := range []sourceIndex{ourSrc, theirSrc} {
+		for key := range index.entries {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		if m, ok := mergeEntry(key, baseSrc, ourSrc, theirSrc, opts); ok {
+			add(m)
+		}
+	}
+	return merged
+}
+
+// mergeEntry merges an entry in the versions, it returns false if the entry is deleted
+// on a side and unchanged on the other
+func mergeEntry(key string, base, ours, theirs sourceIndex, opts WriteOptions) (MergedBlock, bool) {
+	b, inBase := base.entries[key]
+	o, inOurs := ours.entries[key]
+	t, inTheirs := theirs.entries[key]
+	switch {
+	case inOurs && inTheirs:
+		if m, ok := mergeParsedEntries(key, base, ours, theirs, inBase, opts); ok {
+			return m, true
+		}
+		m := mergeEntryBlocks(key, b, o, t)
+		for _, text := range []*string{&m.Ours, &m.Theirs} {
+			*text = strings.TrimSpace(*text) + "\n"
+		}
+		return m, true
+	case inOurs && !inBase:
+		text := ours.text(key, opts)
+		return MergedBlock{Key: key, Ours: text, Theirs: text}, true
+	case inOurs && !sameText(o.Text, b.Text):
+		return MergedBlock{Key: key, Ours: ours.text(key, opts)}, true
+	case inTheirs && !inBase:
+		text := theirs.text(key, opts)
+		return MergedBlock{Key: key, Ours: text, Theirs: text}, true
+	case inTheirs && !sameText(t.Text, b.Text):
+		return MergedBlock{Key: key, Theirs: theirs.text(key, opts)}, true
+	}
+	return MergedBlock{}, false
+}
+
+// mergeParsedEntries merges the fields of an entry parsed in ours and theirs changed from base,
+// and writes them with opts, it returns false unless parsed in all the versions it is in
+func mergeParsedEntries(key string, base, ours, theirs sourceIndex, inBase bool, opts WriteOptions) (MergedBlock, bool) {
+	o, okOurs := ours.parsed[key]
+	t, okTheirs := theirs.parsed[key]
+	b, okBase := base.parsed[key]
+	if !okOurs || !okTheirs || inBase && !okBase {
+		return MergedBlock{}, false
+	}
+	if !inBase {
+		b = bibtex.NewBibEntry("", "")
+	}
+
+	// the values are compared as they are written, regardless of the delimiters
+	canonical := NewWriter(nil, WriteOptions{Delimiter: DelimiterBraces})
+	value := func(entry *bibtex.BibEntry, name string) (string, bool) {
+		v, ok := entry.Fields[name]
+		if !ok {
+			return "", false
+		}
+		return canonical.formatValue(v), true
+	}
+	var names []string
+	for _, entry := range []*bibtex.BibEntry{b, o, t} {
+		for name := range entry.Fields {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	merged := lowerFields(o)
+	var conflicts []string
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		bv, inB := value(b, name)
+		ov, inO := value(o, name)
+		tv, inT := value(t, name)
+		switch {
+		case inO == inT && ov == tv, inB == inT && bv == tv:
+		case inB == inO && bv == ov:
+			setField(merged, t, name)
+		default:
+			conflicts = append(conflicts, name)
+		}
+	}
+	// the entry type is merged as a field
+	conflictType := false
+	switch {
+	case o.Type == t.Type || inBase && b.Type == t.Type:
+	case inBase && b.Type == o.Type:
+		merged.Type = t.Type
+	default:
+		conflicts = append(conflicts, "entry type")
+		conflictType = true
+	}
+
+	theirMerged := lowerFields(merged)
+	for _, name := range conflicts {
+		setField(theirMerged, t, name)
+	}
+	if conflictType {
+		theirMerged.Type = t.Type
+	}
+	return MergedBlock{Key: key, Ours: writeEntry(merged, opts), Theirs: writeEntry(theirMerged, opts), Conflicts: conflicts}, true
+}
+
+// setField sets the field of the entry to the one in from, or removes it if from has none
+func setField(entry, from *bibtex.BibEntry, name string) {
+	if value, ok := from.Fields[name]; ok {
+		entry.Fields[name] = value
+		return
+	}
+	delete(entry.Fields, name)
+}
+
+// mergeEntryBlocks merges the fields of an entry in ours and theirs changed from base, which is empty
+// if the entry is added on both sides, an entry not to be scanned is merged as a whole
+func mergeEntryBlocks(key string, base, ours, theirs Block) MergedBlock {
+	whole := MergedBlock{Key: key, Ours: ours.Text, Theirs: theirs.Text}
+	switch {
+	case sameText(ours.Text, theirs.Text) || base.Text != "" && sameText(theirs.Text, base.Text):
+		whole.Theirs = ours.Text
+	case base.Text != "" && sameText(ours.Text, base.Text):
+		whole.Ours = theirs.Text
+	}
+
+	baseFields, err := blockFieldValues(base.Text)
+	if err != nil && base.Text != "" {
+		return whole
+	}
+	ourFields, err := blockFieldValues(ours.Text)
+	if err != nil {
+		return whole
+	}
+	theirFields, err := blockFieldValues(theirs.Text)
+	if err != nil {
+		return whole
+	}
+	var names []string
+	for _, fields := range []map[string]string{baseFields, ourFields, theirFields} {
+		for name := range fields {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	m := MergedBlock{Key: key, Ours: ours.Text}
+	var theirValues []func(string) (string, error) // the conflicting fields set to theirs
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		name := name
+		b, inBase := baseFields[name]
+		o, inOurs := ourFields[name]
+		t, inTheirs := theirFields[name]
+		apply := func(text string) (string, error) {
+			if !inTheirs {
+				return RemoveBlockField(text, name)
+			}
+			return replaceBlockField(text, name, func(string) string { return t })
+		}
+		switch {
+		case inOurs == inTheirs && o == t, inBase == inTheirs && b == t:
+		case inBase == inOurs && b == o:
+			if m.Ours, err = apply(m.Ours); err != nil {
+				return whole
+			}
+		default:
+			m.Conflicts = append(m.Conflicts, name)
+			theirValues = append(theirValues, apply)
+		}
+	}
+
+	// the entry type is merged as a field
+	switch {
+	case ours.Type == theirs.Type || base.Text != "" && base.Type == theirs.Type:
+	case base.Text != "" && base.Type == ours.Type:
+		m.Ours = setBlockType(m.Ours, theirs.Text)
+	default:
+		m.Conflicts = append(m.Conflicts, "entry type")
+		theirValues = append(theirValues, func(text string) (string, error) {
+			return setBlockType(text, theirs.Text), nil
+		})
+	}
+
+	m.Theirs = m.Ours
+	for _, apply := range theirValues {
+		if m.Theirs, err = apply(m.Theirs); err != nil {
+			return whole
+		}
+	}
+	return m
+}
+
+// blockFieldValues returns the values of the fields in the text of an entry block by the name,
+// as they are written with the delimiters
+func blockFieldValues(text string) (map[string]string, error) {
+	values := make(map[string]string)
+	if text == "" {
+		return values, nil
+	}
+	fields, _, err := scanBlockFields(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		values[f.name] = text[f.valueStart:f.end]
+	}
+	return values, nil
+}
+
+// setBlockType replaces the entry type of the block with the one written in from
+func setBlockType(text, from string) string {
+	to, src := blockStartRE.FindStringSubmatchIndex(text), blockStartRE.FindStringSubmatchIndex(from)
+	if to == nil || src == nil {
+		return text
+	}
+	return text[:to[2]] + from[src[2]:src[3]] + text[to[3]:]
+}
+
+// indexBibItems returns the entries by the cite name, the first one for the duplicates
+func indexBibItems(items []BibItem) map[string]BibItem {
+	index := make(map[string]BibItem, len(items))
+	for _, bi := range items {
+		if _, ok := index[bi.CiteName]; !ok {
+			index[bi.CiteName] = bi
+		}
+	}
+	return index
+}
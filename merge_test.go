@@ -0,0 +1,136 @@
+package bibfuse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeBibItems(t *testing.T) {
+	base := BibItem{CiteName: "key", CiteType: "article", Title: "A", Author: "B", Year: "2019"}
+	ours := base
+	ours.Title, ours.Year = "A2", "2020"
+	theirs := base
+	theirs.Author, theirs.Year = "B2", "2021"
+
+	merged, conflicts := MergeBibItems(base, ours, theirs)
+	want := BibItem{CiteName: "key", CiteType: "article", Title: "A2", Author: "B2", Year: "2020"}
+	if merged != want {
+		t.Errorf("MergeBibItems() => %+v, want %+v", merged, want)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"year"}) {
+		t.Errorf("MergeBibItems() conflicts => %v, want [year]", conflicts)
+	}
+	if got := DiffFields(base, merged); !reflect.DeepEqual(got, []string{"title", "author", "year"}) {
+		t.Errorf("DiffFields() => %v, want [title author year]", got)
+	}
+}
+
+func TestMergeSources(t *testing.T) {
+	base := `@preamble{"\newcommand{\noop}[1]{}"}
+
+@article{a,
+  title = {A},
+  abstract = {Kept.},
+  month = oct,
+  year = 2019,
+}
+
+@misc{b,
+  title = {B},
+}
+
+@misc{c,
+  title = {C},
+}
+
+@misc{d,
+  title = {D},
+}
+`
+	ours := `@preamble{"\newcommand{\noop}[1]{}"}
+
+@article{a,
+  title = {A2},
+  abstract = {Kept.},
+  month = oct,
+  year = 2019,
+}
+
+@misc{b,
+  title = {B2},
+}
+
+@misc{d,
+  title = {D},
+}
+`
+	theirs := `% added in theirs
+
+@book{a,
+  title = {A},
+  abstract = {Kept.},
+  month = oct,
+  year = 2020,
+  note = {J Smith},
+}
+
+@misc{b,
+  title = {B3},
+}
+
+@misc{c,
+  title = {C2},
+}
+
+@misc{e, title = {E}}
+
+@misc{Ab, title = "A" # "b"}
+`
+	// a is changed on both sides in different fields, b differently in the title, c deleted in ours
+	// and changed in theirs, d deleted in theirs, e added in theirs, and Ab added in theirs not to be
+	// parsed; the preamble is deleted in theirs, and the comment added
+	var sb strings.Builder
+	var keys, conflicts []string
+	for _, m := range MergeSources([]byte(base), []byte(ours), []byte(theirs), DefaultWriteOptions()) {
+		sb.WriteString(m.Ours)
+		if m.Key != "" {
+			keys = append(keys, m.Key)
+		}
+		if m.IsConflict() {
+			conflicts = append(conflicts, m.Key+":"+m.Theirs+":"+strings.Join(m.Conflicts, ","))
+		}
+	}
+	// the blocks other than the entries come first, and the entries sorted by the cite name
+	want := `% added in theirs
+
+@book{a,
+    title    = "A2",
+    abstract = "Kept.",
+    month    = oct,
+    note     = "J Smith",
+    year     = 2020,
+}
+
+@misc{Ab, title = "A" # "b"}
+
+@misc{b,
+    title = "B2",
+}
+
+
+@misc{e,
+    title = "E",
+}
+`
+	if sb.String() != want {
+		t.Errorf("MergeSources() => %q, want %q", sb.String(), want)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "ab", "b", "c", "e"}) {
+		t.Errorf("MergeSources() keys => %v, want [a ab b c e]", keys)
+	}
+	wantConflicts := []string{"b:@misc{b,\n    title = \"B3\",\n}\n:title", "c:@misc{c,\n    title = \"C2\",\n}\n:"}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("MergeSources() conflicts => %q, want %q", conflicts, wantConflicts)
+	}
+}
@@ -0,0 +1,390 @@
+package bibfuse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nickng/bibtex"
+)
+
+// parseMu serializes bibtex.Parse, which keeps the bibtex being parsed in package variables
+var parseMu sync.Mutex
+
+var (
+	// blockStartRE matches the `@type{` starting a block at the beginning of a line
+	blockStartRE = regexp.MustCompile(`(?m)^[ \t]*@[ \t]*([A-Za-z]+)[ \t]*[{(]`)
+	stringNameRE = regexp.MustCompile(`^\s*@\s*[A-Za-z]+\s*[{(]\s*([^\s=#,{}()"]+)`)
+)
+
+// Block is a top-level block of bibtex source
+type Block struct {
+	Type   string // the lowercase type (e.g., "article" or "string"), or empty for the text between the entries
+	Text   string
+	Offset int // the byte offset in the source
+	Line   int // the line number starting from 1
+}
+
+// IsEntry checks if the block is a bibliography entry
+func (b Block) IsEntry() bool {
+	switch b.Type {
+	case "", "comment", "preamble", "string":
+		return false
+	}
+	return true
+}
+
+// Snippet returns the first line of the block, shortened for messages
+func (b Block) Snippet() string {
+	snippet := strings.TrimSpace(b.Text)
+	if i := strings.IndexByte(snippet, '\n'); i >= 0 {
+		snippet = snippet[:i]
+	}
+	if len(snippet) > 60 {
+		snippet = snippet[:57] + "..."
+	}
+	return snippet
+}
+
+// Key returns the cite name of an entry or the name of a string variable, or an empty string
+func (b Block) Key() string {
+	if m := stringNameRE.FindStringSubmatch(b.Text); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// SplitBlocks splits the source at the `@type{` boundaries at the beginning of the lines,
+// a block ends at its closing brace, or at the next boundary if the braces don't match
+func SplitBlocks(src []byte) []Block {
+	text := string(src)
+	starts := blockStartRE.FindAllStringSubmatchIndex(text, -1)
+
+	var blocks []Block
+	line, lineOffset := 1, 0
+	addBlock := func(blockType string, from, to int) {
+		if from >= to {
+			return
+		}
+		line += strings.Count(text[lineOffset:from], "\n")
+		lineOffset = from
+		blocks = append(blocks, Block{Type: blockType, Text: text[from:to], Offset: from, Line: line})
+	}
+
+	pos := 0
+	for i, start := range starts {
+		next := len(text)
+		if i+1 < len(starts) {
+			next = starts[i+1][0]
+		}
+		end := matchBlockEnd(text, start[1]-1)
+		if end < 0 || end > next {
+			end = next
+		}
+		addBlock("", pos, start[0])
+		addBlock(strings.ToLower(text[start[2]:start[3]]), start[0], end)
+		pos = end
+	}
+	addBlock("", pos, len(text))
+	return blocks
+}
+
+// matchBlockEnd returns the offset after the brace or parenthesis closing the one at open, or -1
+func matchBlockEnd(text string, open int) int {
+	closing := byte('}')
+	if text[open] == '(' {
+		closing = ')'
+	}
+	depth := 0
+	for i := open + 1; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closing && depth == 0:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// bareValues returns the bare words (i.e., string variables) used in the field values of a block
+func bareValues(text string) []string {
+	i := strings.IndexAny(text, "{(")
+	if i < 0 {
+		return nil
+	}
+	var words []string
+	expectValue := false
+	for i++; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '=' || c == '#':
+			expectValue = true
+			i++
+		case c == ',':
+			expectValue = false
+			i++
+		case c == '{' || c == '"':
+			i = skipDelimited(text, i)
+			expectValue = false
+		case c == '}' || c == ')':
+			return words
+		case expectValue && isBareChar(c):
+			j := i
+			for j < len(text) && isBareChar(text[j]) {
+				j++
+			}
+			if word := text[i:j]; strings.Trim(word, "0123456789") != "" {
+				words = append(words, word)
+			}
+			i = j
+			expectValue = false
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// skipDelimited returns the offset after the braced or quoted string starting at i
+func skipDelimited(text string, i int) int {
+	depth := 0
+	quoted := text[i] == '"'
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 && !quoted {
+				return j + 1
+			}
+			depth--
+		case '"':
+			if quoted && depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(text)
+}
+
+func isBareChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("_-:./+'!?*&$", c) >= 0
+}
+
+// ParseError is a block which failed to parse
+type ParseError struct {
+	File    string
+	Line    int
+	Snippet string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v: %s", e.File, e.Line, e.Err, e.Snippet)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses the bibtex from r, it is safe for concurrent use
+func Parse(r io.Reader) (*bibtex.BibTex, error) {
+	return ParseWithStrings(r, nil)
+}
+
+// monthStrings are the month macros predefined by the BibTeX styles, e.g., month = oct
+var monthStrings = map[string]string{
+	"jan": "January", "feb": "February", "mar": "March", "apr": "April", "may": "May", "jun": "June",
+	"jul": "July", "aug": "August", "sep": "September", "oct": "October", "nov": "November", "dec": "December",
+}
+
+// IsMonthMacro reports whether name is one of the predefined month macros
+func IsMonthMacro(name string) bool {
+	_, ok := monthStrings[name]
+	return ok
+}
+
+// withMonths returns the string variables with the month macros not redefined
+func withMonths(defined map[string]string) map[string]string {
+	values := make(map[string]string, len(defined)+len(monthStrings))
+	for name, value := range monthStrings {
+		values[name] = value
+	}
+	for name, value := range defined {
+		values[name] = value
+	}
+	return values
+}
+
+// ParseWithStrings parses the bibtex from r with the string variables defined
+// elsewhere (e.g., in another file), the returned bibtex has only the ones defined in r
+func ParseWithStrings(r io.Reader, defined map[string]string) (*bibtex.BibTex, error) {
+	// read outside the lock so that only the parsing is serialized
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	defined = withMonths(defined)
+
+	// bibtex.Parse exits on undefined string variables, check them beforehand
+	known := make(map[string]bool)
+	local := make(map[string]bool)
+	var used []string
+	for _, block := range SplitBlocks(data) {
+		if block.Type == "" || block.Type == "comment" {
+			continue
+		}
+		for _, name := range bareValues(block.Text) {
+			if known[name] {
+				continue
+			}
+			if _, ok := defined[name]; !ok {
+				return nil, fmt.Errorf("line %d: %w: %s", block.Line, bibtex.ErrUnknownStringVar, name)
+			}
+			known[name] = true
+			used = append(used, name)
+		}
+		if block.Type == "string" {
+			if m := stringNameRE.FindStringSubmatch(block.Text); m != nil {
+				known[m[1]] = true
+				local[m[1]] = true
+			}
+		}
+	}
+
+	// the definitions are prepended without a newline to keep the line numbers
+	bib, err := parseBibtex(append([]byte(stringDefinitions(defined, used, "")), blankComments(data)...))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range used {
+		if !local[name] {
+			delete(bib.StringVar, name)
+		}
+	}
+	return bib, nil
+}
+
+// blankComments replaces the @comment blocks and the text between the blocks (e.g., % comments),
+// which bibtex.Parse can't always skip, with their newlines
+func blankComments(data []byte) []byte {
+	blanked := make([]byte, 0, len(data))
+	last := 0
+	for _, block := range SplitBlocks(data) {
+		if block.Type != "" && block.Type != "comment" {
+			continue
+		}
+		blanked = append(blanked, data[last:block.Offset]...)
+		blanked = append(blanked, strings.Repeat("\n", strings.Count(block.Text, "\n"))...)
+		last = block.Offset + len(block.Text)
+	}
+	return append(blanked, data[last:]...)
+}
+
+func parseBibtex(data []byte) (*bibtex.BibTex, error) {
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	// a failed parse leaves the scanner in the middle of a field, scanning a comma resets it
+	bibtex.NewScanner(strings.NewReader(",")).Scan()
+	return bibtex.Parse(bytes.NewReader(data))
+}
+
+// ParseTolerant parses the blocks in src one by one, and skips and reports the ones failing
+func ParseTolerant(src []byte, file string) (*bibtex.BibTex, []*ParseError) {
+	return ParseTolerantWithStrings(src, file, nil)
+}
+
+// ParseTolerantWithStrings is ParseTolerant with the string variables defined elsewhere
+func ParseTolerantWithStrings(src []byte, file string, defined map[string]string) (*bibtex.BibTex, []*ParseError) {
+	bib := bibtex.NewBibTex()
+	values := withMonths(defined)
+	var errs []*ParseError
+
+	for _, block := range SplitBlocks(src) {
+		if block.Type == "" || block.Type == "comment" {
+			continue
+		}
+		fail := func(err error) {
+			errs = append(errs, &ParseError{File: file, Line: block.Line, Snippet: block.Snippet(), Err: err})
+		}
+
+		used := bareValues(block.Text)
+		var unknown []string
+		for _, name := range used {
+			if _, ok := values[name]; !ok {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			fail(fmt.Errorf("%w: %s", bibtex.ErrUnknownStringVar, strings.Join(unknown, ", ")))
+			continue
+		}
+
+		// parse the block alone with the definitions of the string variables it uses
+		parsed, err := parseBibtex([]byte(stringDefinitions(values, used, "\n") + block.Text))
+		if err != nil {
+			// the position is relative to the block with the definitions, so report the block line instead
+			var parseErr *bibtex.ErrParse
+			if errors.As(err, &parseErr) {
+				err = errors.New(parseErr.Err)
+			}
+			fail(err)
+			continue
+		}
+
+		switch {
+		case block.Type == "string":
+			m := stringNameRE.FindStringSubmatch(block.Text)
+			if m == nil || parsed.StringVar[m[1]] == nil {
+				fail(fmt.Errorf("no string parsed"))
+				continue
+			}
+			values[m[1]] = parsed.StringVar[m[1]].String()
+			bib.AddStringVar(m[1], bibtex.NewBibConst(values[m[1]]))
+		case block.Type == "preamble":
+			if len(parsed.Preambles) == 0 {
+				fail(fmt.Errorf("no preamble parsed"))
+				continue
+			}
+			for _, preamble := range parsed.Preambles {
+				bib.AddPreamble(preamble)
+			}
+		default:
+			if len(parsed.Entries) == 0 {
+				fail(fmt.Errorf("no entry parsed"))
+				continue
+			}
+			for _, entry := range parsed.Entries {
+				bib.AddEntry(entry)
+			}
+		}
+	}
+	return bib, errs
+}
+
+// stringDefinitions returns the @string definitions of the names with the values, separated by sep
+func stringDefinitions(values map[string]string, names []string, sep string) string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	var sb strings.Builder
+	for i, name := range sorted {
+		if i > 0 && sorted[i-1] == name {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("@string{%s = {%s}}%s", name, value, sep))
+	}
+	return sb.String()
+}
@@ -0,0 +1,208 @@
+package bibfuse
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nickng/bibtex"
+)
+
+func TestParseConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sb strings.Builder
+			for j := 0; j <= i; j++ {
+				sb.WriteString(fmt.Sprintf("@article{key%d_%d,\ntitle={{Title %d}},\n}\n", i, j, j))
+			}
+			parsed, err := Parse(strings.NewReader(sb.String()))
+			if err != nil {
+				t.Errorf("Parse() err => %v, want nil", err)
+				return
+			}
+			if len(parsed.Entries) != i+1 {
+				t.Errorf("Parse() => %d entries, want %d", len(parsed.Entries), i+1)
+				return
+			}
+			if name := parsed.Entries[0].CiteName; name != fmt.Sprintf("key%d_0", i) {
+				t.Errorf("Parse().Entries[0].CiteName => %v, want key%d_0", name, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestParseAfterFailure(t *testing.T) {
+	if _, err := Parse(strings.NewReader("@article{broken,\ntitle={{Broken}}\n")); err == nil {
+		t.Fatalf("Parse() err => nil, want syntax error")
+	}
+	parsed, err := Parse(strings.NewReader("@article{fine,\ntitle={{Fine}},\n}\n"))
+	if err != nil {
+		t.Fatalf("Parse() err => %v, want nil", err)
+	}
+	if len(parsed.Entries) != 1 || parsed.Entries[0].CiteName != "fine" {
+		t.Errorf("Parse() => %v, want the entry fine", parsed.Entries)
+	}
+}
+
+func TestSplitBlocks(t *testing.T) {
+	src := "% references\n@string{ieee = \"IEEE\"}\n\n@article{a,\n  title = {{A}},\n}\n@article{b,\n  title = {B\n@misc{c, title = \"C\"}\n"
+	blocks := SplitBlocks([]byte(src))
+	want := []struct {
+		blockType string
+		line      int
+		text      string
+	}{
+		{"", 1, "% references\n"},
+		{"string", 2, "@string{ieee = \"IEEE\"}"},
+		{"", 2, "\n\n"},
+		{"article", 4, "@article{a,\n  title = {{A}},\n}"},
+		{"", 6, "\n"},
+		{"article", 7, "@article{b,\n  title = {B\n"},
+		{"misc", 9, "@misc{c, title = \"C\"}"},
+		{"", 9, "\n"},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("SplitBlocks() => %d blocks %v, want %d", len(blocks), blocks, len(want))
+	}
+	for i, w := range want {
+		if blocks[i].Type != w.blockType || blocks[i].Line != w.line || blocks[i].Text != w.text {
+			t.Errorf("SplitBlocks()[%d] => %q line %d %q, want %q line %d %q", i, blocks[i].Type, blocks[i].Line, blocks[i].Text, w.blockType, w.line, w.text)
+		}
+		if src[blocks[i].Offset:blocks[i].Offset+len(blocks[i].Text)] != blocks[i].Text {
+			t.Errorf("SplitBlocks()[%d].Offset => %d, mismatch with the text", i, blocks[i].Offset)
+		}
+	}
+}
+
+var bareValuestests = []struct {
+	in  string
+	out []string
+}{
+	{"@article{a, title = {T}, journal = ieee, year = 2021}", []string{"ieee"}},
+	{"@article{a, journal = ieee # \" Trans.\" # {x}, month = jan,}", []string{"ieee", "jan"}},
+	{"@string{acm = \"ACM\"}", nil},
+	{"@article{a, title = \"A = b, c # d\"}", nil},
+}
+
+func TestBareValues(t *testing.T) {
+	for _, tt := range bareValuestests {
+		if got := bareValues(tt.in); !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("bareValues(%q) => %v, want %v", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestParseUnknownStringVar(t *testing.T) {
+	_, err := Parse(strings.NewReader("@article{a,\ntitle={A},\njournal=ieee,\n}\n"))
+	if !errors.Is(err, bibtex.ErrUnknownStringVar) {
+		t.Errorf("Parse() err => %v, want %v", err, bibtex.ErrUnknownStringVar)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	bib, err := Parse(strings.NewReader("% my references\n@misc{a, title = {A}}\nnotes\n@misc{b, title = {B}}\n"))
+	if err != nil || len(bib.Entries) != 2 {
+		t.Errorf("Parse() with comments => %v, want 2 entries", err)
+	}
+}
+
+func TestParseCommentBlocks(t *testing.T) {
+	bib, err := Parse(strings.NewReader("@comment{jabref-meta: databaseType:bibtex;}\n@misc{a, title = {A}}\n@comment{@misc{c, title = {C}}}\n@misc{b, title = {B}}\n"))
+	if err != nil || len(bib.Entries) != 2 {
+		t.Fatalf("Parse() with @comment => %v, want 2 entries", err)
+	}
+	// the entries in the @comment blocks are not parsed
+	for _, entry := range bib.Entries {
+		if entry.CiteName == "c" {
+			t.Errorf("Parse() with @comment => %v, want a and b", entry.CiteName)
+		}
+	}
+}
+
+func TestParseTolerant(t *testing.T) {
+	src := `@string{ieee = "IEEE"}
+@article{good1,
+  title = {{Good One}},
+  journal = ieee,
+}
+
+@article{broken,
+  title = {{Broken},
+}
+
+@article{unknown,
+  journal = acm,
+}
+@inproceedings{good2, title = "Good Two"}
+`
+	bib, errs := ParseTolerant([]byte(src), "refs.bib")
+	if len(bib.Entries) != 2 {
+		t.Fatalf("ParseTolerant() => %d entries, want 2", len(bib.Entries))
+	}
+	if bib.Entries[0].CiteName != "good1" || bib.Entries[1].CiteName != "good2" {
+		t.Errorf("ParseTolerant() => %v %v, want good1 good2", bib.Entries[0].CiteName, bib.Entries[1].CiteName)
+	}
+	if journal := bib.Entries[0].Fields["journal"].String(); journal != "IEEE" {
+		t.Errorf("ParseTolerant() journal => %v, want IEEE", journal)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("ParseTolerant() errs => %v, want 2", errs)
+	}
+	if errs[0].Line != 7 || errs[0].Snippet != "@article{broken," {
+		t.Errorf("ParseTolerant() errs[0] => %v, want refs.bib:7 @article{broken,", errs[0])
+	}
+	if errs[1].Line != 11 || !errors.Is(errs[1], bibtex.ErrUnknownStringVar) {
+		t.Errorf("ParseTolerant() errs[1] => %v, want refs.bib:11 unknown string variable", errs[1])
+	}
+	if !strings.HasPrefix(errs[1].Error(), "refs.bib:11: ") {
+		t.Errorf("ParseError.Error() => %v, want refs.bib:11: prefix", errs[1])
+	}
+}
+
+func TestParseWithStrings(t *testing.T) {
+	src := "@string{acm = \"ACM\"}\n@article{a,\ntitle={A},\njournal=ieee,\npublisher=acm,\n}\n"
+	bib, err := ParseWithStrings(strings.NewReader(src), map[string]string{"ieee": "IEEE"})
+	if err != nil {
+		t.Fatalf("ParseWithStrings() err => %v, want nil", err)
+	}
+	if journal := bib.Entries[0].Fields["journal"].String(); journal != "IEEE" {
+		t.Errorf("ParseWithStrings() journal => %v, want IEEE", journal)
+	}
+	if _, ok := bib.StringVar["ieee"]; ok {
+		t.Errorf("ParseWithStrings().StringVar => %v, want only acm", bib.StringVar)
+	}
+	if _, ok := bib.StringVar["acm"]; !ok {
+		t.Errorf("ParseWithStrings().StringVar => %v, want acm", bib.StringVar)
+	}
+}
+
+func TestParseMonths(t *testing.T) {
+	src := "@string{may = \"Spring\"}\n@misc{a,\ntitle={A},\nmonth=oct,\n}\n@misc{b,\ntitle={B},\nmonth=may,\n}\n"
+	bib, err := ParseWithStrings(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatalf("ParseWithStrings() err => %v, want nil", err)
+	}
+	for i, want := range []string{"October", "Spring"} {
+		if month := bib.Entries[i].Fields["month"].String(); month != want {
+			t.Errorf("ParseWithStrings() month => %v, want %v", month, want)
+		}
+	}
+	if _, ok := bib.StringVar["oct"]; ok {
+		t.Errorf("ParseWithStrings().StringVar => %v, want only may", bib.StringVar)
+	}
+
+	tolerant, errs := ParseTolerant([]byte(src), "months.bib")
+	if len(errs) != 0 || len(tolerant.Entries) != 2 {
+		t.Fatalf("ParseTolerant() => %d entries, %v, want 2 entries", len(tolerant.Entries), errs)
+	}
+	if month := tolerant.Entries[0].Fields["month"].String(); month != "October" {
+		t.Errorf("ParseTolerant() month => %v, want October", month)
+	}
+}
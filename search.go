@@ -0,0 +1,143 @@
+package bibfuse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchFields are the fields searched by the terms, venue is the journal or the booktitle
+var SearchFields = []string{"title", "author", "venue", "note", "metanote"}
+
+// searchAliases maps the qualifiers to the fields searched
+var searchAliases = map[string]string{
+	"booktitle": "venue",
+	"journal":   "venue",
+}
+
+// SearchQuery is a parsed search query, e.g., `rfid author:mizutani year:2019..2021 type:inproceedings`
+type SearchQuery struct {
+	Terms    []string            // the terms searched in all the SearchFields
+	Fields   map[string][]string // the terms searched in a field (e.g., author)
+	Types    []string            // the citation types, any of them
+	Keys     []string            // the prefixes of the cite names, any of them
+	YearFrom int                 // the first year, or 0
+	YearTo   int                 // the last year, or 0
+}
+
+// ParseSearchQuery parses the terms and the qualifiers (field:term) in query,
+// a term can be quoted to search a phrase
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	q := &SearchQuery{Fields: make(map[string][]string)}
+	for _, token := range splitSearchQuery(query) {
+		qualifier, term := "", token
+		if i := strings.IndexByte(token, ':'); i > 0 && !strings.HasPrefix(token, "\"") {
+			qualifier, term = strings.ToLower(token[:i]), strings.Trim(token[i+1:], "\"")
+		} else {
+			term = strings.Trim(term, "\"")
+		}
+		if term == "" {
+			continue
+		}
+		if alias, ok := searchAliases[qualifier]; ok {
+			qualifier = alias
+		}
+
+		switch qualifier {
+		case "":
+			q.Terms = append(q.Terms, term)
+		case "type":
+			q.Types = append(q.Types, strings.ToLower(term))
+		case "key":
+			q.Keys = append(q.Keys, term)
+		case "year":
+			from, to, err := parseYearRange(term)
+			if err != nil {
+				return nil, fmt.Errorf("search: %w", err)
+			}
+			q.YearFrom, q.YearTo = from, to
+		default:
+			if !isSearchField(qualifier) {
+				return nil, fmt.Errorf("search: unknown qualifier %q, want one of %s, year, type, or key", qualifier, strings.Join(SearchFields, ", "))
+			}
+			q.Fields[qualifier] = append(q.Fields[qualifier], term)
+		}
+	}
+	return q, nil
+}
+
+// splitSearchQuery splits the query at the spaces outside quotes
+func splitSearchQuery(query string) []string {
+	var tokens []string
+	var sb strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			sb.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if sb.Len() > 0 {
+				tokens = append(tokens, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		tokens = append(tokens, sb.String())
+	}
+	return tokens
+}
+
+// parseYearRange parses `2019`, `2019..2021`, `2019..`, or `..2021`, 0 is unbounded
+func parseYearRange(term string) (int, int, error) {
+	fromTerm, toTerm := term, term
+	if i := strings.Index(term, ".."); i >= 0 {
+		fromTerm, toTerm = term[:i], term[i+2:]
+	}
+	var from, to int
+	var err error
+	if fromTerm != "" {
+		if from, err = strconv.Atoi(fromTerm); err != nil {
+			return 0, 0, fmt.Errorf("year:%s: want YYYY or YYYY..YYYY", term)
+		}
+	}
+	if toTerm != "" {
+		if to, err = strconv.Atoi(toTerm); err != nil {
+			return 0, 0, fmt.Errorf("year:%s: want YYYY or YYYY..YYYY", term)
+		}
+	}
+	return from, to, nil
+}
+
+func isSearchField(name string) bool {
+	for _, field := range SearchFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchExpression returns the FTS5 expression matching the terms as prefixes,
+// or an empty string if the query has no terms
+func (q *SearchQuery) MatchExpression() string {
+	var exprs []string
+	for _, term := range q.Terms {
+		exprs = append(exprs, ftsPhrase(term))
+	}
+	for _, field := range SearchFields {
+		for _, term := range q.Fields[field] {
+			exprs = append(exprs, field+" : "+ftsPhrase(term))
+		}
+	}
+	return strings.Join(exprs, " AND ")
+}
+
+// ftsPhrase quotes the term as an FTS5 phrase matching the prefix
+func ftsPhrase(term string) string {
+	return "\"" + strings.ReplaceAll(term, "\"", "\"\"") + "\"*"
+}
@@ -0,0 +1,60 @@
+package bibfuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+var searchquerytests = []struct {
+	in   string
+	want SearchQuery
+	expr string
+}{
+	{
+		"rfid middleware",
+		SearchQuery{Terms: []string{"rfid", "middleware"}, Fields: map[string][]string{}},
+		`"rfid"* AND "middleware"*`,
+	},
+	{
+		`author:mizutani year:2019..2021 type:InProceedings title:"rfid middleware"`,
+		SearchQuery{
+			Fields:   map[string][]string{"author": {"mizutani"}, "title": {"rfid middleware"}},
+			Types:    []string{"inproceedings"},
+			YearFrom: 2019,
+			YearTo:   2021,
+		},
+		`title : "rfid middleware"* AND author : "mizutani"*`,
+	},
+	{
+		`journal:sensors year:..2020 key:iomz`,
+		SearchQuery{Fields: map[string][]string{"venue": {"sensors"}}, Keys: []string{"iomz"}, YearTo: 2020},
+		`venue : "sensors"*`,
+	},
+	{
+		"year:2019",
+		SearchQuery{Fields: map[string][]string{}, YearFrom: 2019, YearTo: 2019},
+		"",
+	},
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	for _, tt := range searchquerytests {
+		q, err := ParseSearchQuery(tt.in)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q) err => %v, want nil", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(*q, tt.want) {
+			t.Errorf("ParseSearchQuery(%q) => %+v, want %+v", tt.in, *q, tt.want)
+		}
+		if expr := q.MatchExpression(); expr != tt.expr {
+			t.Errorf("ParseSearchQuery(%q).MatchExpression() => %v, want %v", tt.in, expr, tt.expr)
+		}
+	}
+
+	for _, in := range []string{"colour:red", "year:recent", "year:2019..soon"} {
+		if _, err := ParseSearchQuery(in); err == nil {
+			t.Errorf("ParseSearchQuery(%q) err => nil, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package bibfuse
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nickng/bibtex"
+)
+
+// selectorTerm is a condition of a Selector
+type selectorTerm struct {
+	negate bool
+	match  func(bi BibItem, tags []string) bool
+}
+
+// Selector selects the entries matching all of its terms
+type Selector []selectorTerm
+
+// ParseSelector parses the filter expression, the terms separated by spaces are all required:
+//
+//	type:article,inproceedings  the citation type is any of them
+//	year:2018..                 the year is in the range (also 2018, 2018..2020, or ..2020)
+//	has:doi                     the field has a value other than a placeholder
+//	todo:author                 the field is (TODO), or any field with todo:*
+//	optional:doi                the field is (OPTIONAL), or any field with optional:*
+//	tag:thesis                  the entry is tagged
+//	title~(?i)rfid              the field matches the regular expression
+//
+// and a term prefixed with ! is negated, a term can be quoted to have spaces
+func ParseSelector(expr string) (Selector, error) {
+	var s Selector
+	for _, token := range splitSearchQuery(expr) {
+		term := selectorTerm{}
+		if strings.HasPrefix(token, "!") {
+			term.negate = true
+			token = token[1:]
+		}
+		token = strings.Trim(token, "\"")
+
+		match, err := parseSelectorTerm(token)
+		if err != nil {
+			return nil, fmt.Errorf("select: %s: %w", token, err)
+		}
+		term.match = match
+		s = append(s, term)
+	}
+	return s, nil
+}
+
+func parseSelectorTerm(token string) (func(BibItem, []string) bool, error) {
+	if i := strings.IndexAny(token, ":~"); i > 0 && token[i] == '~' {
+		fieldName := token[:i]
+		if _, ok := bibItemBibtexIndex[fieldName]; !ok {
+			return nil, fmt.Errorf("unknown field %q", fieldName)
+		}
+		re, err := regexp.Compile(strings.Trim(token[i+1:], "\""))
+		if err != nil {
+			return nil, err
+		}
+		return func(bi BibItem, _ []string) bool {
+			value, _ := bi.FieldValueByBibTexName(fieldName)
+			return re.MatchString(value)
+		}, nil
+	}
+
+	i := strings.IndexByte(token, ':')
+	if i <= 0 {
+		return nil, fmt.Errorf("want qualifier:value or field~regex")
+	}
+	qualifier, value := token[:i], strings.Trim(token[i+1:], "\"")
+	switch qualifier {
+	case "type":
+		types := strings.Split(strings.ToLower(value), ",")
+		return func(bi BibItem, _ []string) bool {
+			for _, citeType := range types {
+				if bi.CiteType == citeType {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "year":
+		from, to, err := parseYearRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(bi BibItem, _ []string) bool {
+			year, err := strconv.Atoi(bi.Year)
+			return err == nil && (from == 0 || year >= from) && (to == 0 || year <= to)
+		}, nil
+	case "has":
+		if _, ok := bibItemBibtexIndex[value]; !ok {
+			return nil, fmt.Errorf("unknown field %q", value)
+		}
+		return func(bi BibItem, _ []string) bool {
+			v, _ := bi.FieldValueByBibTexName(value)
+			return !isPlaceholder(v)
+		}, nil
+	case "todo", "optional":
+		placeholder := "(" + strings.ToUpper(qualifier) + ")"
+		if value == "*" {
+			return func(bi BibItem, _ []string) bool {
+				for _, v := range bi.AllFields(ByBibTexName) {
+					if v == placeholder {
+						return true
+					}
+				}
+				return false
+			}, nil
+		}
+		if _, ok := bibItemBibtexIndex[value]; !ok {
+			return nil, fmt.Errorf("unknown field %q", value)
+		}
+		return func(bi BibItem, _ []string) bool {
+			v, _ := bi.FieldValueByBibTexName(value)
+			return v == placeholder
+		}, nil
+	case "tag":
+		return func(_ BibItem, tags []string) bool {
+			for _, tag := range tags {
+				if tag == value {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown qualifier %q, want type, year, has, todo, optional, or tag", qualifier)
+}
+
+// Match checks if the entry with the tags is selected
+func (s Selector) Match(bi BibItem, tags []string) bool {
+	for _, term := range s {
+		if term.match(bi, tags) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey is a key of a SortSpec
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// SortSpec orders the entries by the keys in turn
+type SortSpec []sortKey
+
+// sortFields are the fields the entries can be sorted by, key is the cite name
+var sortFields = map[string]string{
+	"author": "author",
+	"key":    "cite_name",
+	"title":  "title",
+	"type":   "cite_type",
+	"year":   "year",
+}
+
+// ParseSortSpec parses the keys separated by commas, e.g., `year desc, author` or `-year,key`,
+// a key is author, key, title, type, or year followed by asc or desc
+func ParseSortSpec(spec string) (SortSpec, error) {
+	var ss SortSpec
+	for _, part := range strings.Split(spec, ",") {
+		words := strings.Fields(strings.ToLower(part))
+		if len(words) == 0 {
+			continue
+		}
+		key := sortKey{field: words[0]}
+		if strings.HasPrefix(key.field, "-") {
+			key.field, key.desc = key.field[1:], true
+		}
+		if _, ok := sortFields[key.field]; !ok {
+			return nil, fmt.Errorf("sort: unknown key %q, want author, key, title, type, or year", key.field)
+		}
+		switch {
+		case len(words) == 1:
+		case len(words) == 2 && words[1] == "desc":
+			key.desc = true
+		case len(words) == 2 && words[1] == "asc":
+		default:
+			return nil, fmt.Errorf("sort: %q: want the key followed by asc or desc", strings.TrimSpace(part))
+		}
+		ss = append(ss, key)
+	}
+	return ss, nil
+}
+
+// Sort sorts the entries stably, the years are compared as numbers
+func (ss SortSpec) Sort(items []BibItem) {
+	ss.sortStable(items, func(i int, field string) string {
+		value, _ := items[i].FieldValueByBibTexName(field)
+		return value
+	})
+}
+
+// SortEntries sorts the parsed entries stably in the same way as Sort
+func (ss SortSpec) SortEntries(entries []*bibtex.BibEntry) {
+	ss.sortStable(entries, func(i int, field string) string {
+		switch field {
+		case "cite_name":
+			return entries[i].CiteName
+		case "cite_type":
+			return entries[i].Type
+		}
+		if value, ok := entries[i].Fields[field]; ok {
+			return value.String()
+		}
+		return ""
+	})
+}
+
+// sortStable sorts the slice with the values of the fields of the i-th element
+func (ss SortSpec) sortStable(slice interface{}, value func(i int, field string) string) {
+	sort.SliceStable(slice, func(i, j int) bool {
+		for _, key := range ss {
+			a, b := value(i, sortFields[key.field]), value(j, sortFields[key.field])
+			cmp := compareSortValues(key.field, a, b)
+			if cmp == 0 {
+				continue
+			}
+			return (cmp < 0) != key.desc
+		}
+		return false
+	})
+}
+
+func compareSortValues(field, a, b string) int {
+	if field == "year" {
+		ya, errA := strconv.Atoi(a)
+		yb, errB := strconv.Atoi(b)
+		if errA == nil && errB == nil {
+			return ya - yb
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
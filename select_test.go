@@ -0,0 +1,121 @@
+package bibfuse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nickng/bibtex"
+)
+
+// selectItems returns the BibItems for the selector and sort tests
+func selectItems() []BibItem {
+	items := make([]BibItem, 4)
+	for i, fields := range []map[string]string{
+		{"cite_name": "a", "cite_type": "article", "year": "2019", "author": "Doe, John", "doi": "10.1/a", "title": "RFID Middleware"},
+		{"cite_name": "b", "cite_type": "inproceedings", "year": "2021", "author": "Abe, Ken", "doi": "(OPTIONAL)", "title": "Other"},
+		{"cite_name": "c", "cite_type": "article", "year": "2017", "author": "Roe, Jane", "doi": "", "title": "(TODO)"},
+		{"cite_name": "d", "cite_type": "article", "year": "2021", "author": "Abe, Ken", "doi": "10.1/d", "title": "rfid tags"},
+	} {
+		items[i] = NewBibItem()
+		for name, value := range fields {
+			_ = items[i].SetFieldByBibTexName(name, value)
+		}
+	}
+	return items
+}
+
+var selectortests = []struct {
+	expr string
+	want []string
+}{
+	{"type:article year:2018..", []string{"a", "d"}},
+	{"type:article,inproceedings year:..2019", []string{"a", "c"}},
+	{"has:doi", []string{"a", "d"}},
+	{"optional:doi", []string{"b"}},
+	{"todo:*", []string{"c"}},
+	{"!todo:*", []string{"a", "b", "d"}},
+	{"title~(?i)rfid", []string{"a", "d"}},
+	{"tag:thesis !tag:draft", []string{"a"}},
+	{"", []string{"a", "b", "c", "d"}},
+}
+
+func TestSelector(t *testing.T) {
+	tags := map[string][]string{"a": {"thesis"}, "b": {"thesis", "draft"}}
+	for _, tt := range selectortests {
+		s, err := ParseSelector(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSelector(%q) err => %v, want nil", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, bi := range selectItems() {
+			if s.Match(bi, tags[bi.CiteName]) {
+				got = append(got, bi.CiteName)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSelector(%q).Match() => %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"colour:red", "has:colour", "year:soon", "title~(", "journal"} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("ParseSelector(%q) err => nil, want an error", expr)
+		}
+	}
+}
+
+var sortspectests = []struct {
+	spec string
+	want []string
+}{
+	{"key", []string{"a", "b", "c", "d"}},
+	{"year desc, key", []string{"b", "d", "a", "c"}},
+	{"-year,author", []string{"b", "d", "a", "c"}},
+	{"author, year desc", []string{"b", "d", "a", "c"}},
+	{"type, year", []string{"c", "a", "d", "b"}},
+}
+
+func TestSortSpec(t *testing.T) {
+	for _, tt := range sortspectests {
+		ss, err := ParseSortSpec(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSortSpec(%q) err => %v, want nil", tt.spec, err)
+			continue
+		}
+		items := selectItems()
+		ss.Sort(items)
+		var got []string
+		for _, bi := range items {
+			got = append(got, bi.CiteName)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSortSpec(%q).Sort() => %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"colour", "year down", "year desc asc"} {
+		if _, err := ParseSortSpec(spec); err == nil {
+			t.Errorf("ParseSortSpec(%q) err => nil, want an error", spec)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	var entries []*bibtex.BibEntry
+	for _, bi := range selectItems() {
+		entries = append(entries, bi.ToBibEntry())
+	}
+	ss, err := ParseSortSpec("year desc, key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.SortEntries(entries)
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.CiteName)
+	}
+	if want := []string{"b", "d", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SortEntries() => %v, want %v", got, want)
+	}
+}
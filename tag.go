@@ -0,0 +1,71 @@
+package bibfuse
+
+import (
+	"sort"
+	"strings"
+)
+
+// SplitKeywords returns the keywords separated by commas or semicolons in the value
+func SplitKeywords(value string) []string {
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, keyword := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" || seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+// JoinKeywords returns the tags as the value of a keywords field
+func JoinKeywords(tags []string) string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+// TagFilter selects the entries with all the tags included and none of the tags excluded
+type TagFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// NewTagFilter returns the TagFilter of the tags, the ones starting with `!` are excluded
+func NewTagFilter(tags []string) TagFilter {
+	var f TagFilter
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "!") {
+			f.Exclude = append(f.Exclude, tag[1:])
+		} else {
+			f.Include = append(f.Include, tag)
+		}
+	}
+	return f
+}
+
+// IsEmpty checks if the filter selects every entry
+func (f TagFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match checks if the entry with the tags is selected
+func (f TagFilter) Match(tags []string) bool {
+	has := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		has[tag] = true
+	}
+	for _, tag := range f.Include {
+		if !has[tag] {
+			return false
+		}
+	}
+	for _, tag := range f.Exclude {
+		if has[tag] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package bibfuse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitKeywords(t *testing.T) {
+	got := SplitKeywords("thesis, rfid;; draft ,thesis")
+	want := []string{"thesis", "rfid", "draft"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitKeywords() => %v, want %v", got, want)
+	}
+	if got := JoinKeywords(want); got != "draft, rfid, thesis" {
+		t.Errorf("JoinKeywords() => %v, want draft, rfid, thesis", got)
+	}
+}
+
+var tagfiltertests = []struct {
+	tags []string
+	want bool
+}{
+	{[]string{"thesis"}, true},
+	{[]string{"thesis", "rfid"}, true},
+	{[]string{"thesis", "draft"}, false},
+	{[]string{"rfid"}, false},
+	{nil, false},
+}
+
+func TestTagFilter(t *testing.T) {
+	f := NewTagFilter([]string{"thesis", "!draft"})
+	if !reflect.DeepEqual(f, TagFilter{Include: []string{"thesis"}, Exclude: []string{"draft"}}) {
+		t.Errorf("NewTagFilter() => %+v, want include thesis and exclude draft", f)
+	}
+	for _, tt := range tagfiltertests {
+		if got := f.Match(tt.tags); got != tt.want {
+			t.Errorf("Match(%v) => %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+	if !NewTagFilter(nil).Match(nil) {
+		t.Errorf("NewTagFilter(nil).Match(nil) => false, want true")
+	}
+}
@@ -0,0 +1,256 @@
+package bibfuse
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// the styles to convert the titles to
+const (
+	TitleCaseKeep     = ""
+	TitleCaseSentence = "sentence"
+	TitleCaseTitle    = "title"
+)
+
+var (
+	// ErrUnbalancedBraces is reported for a title with an unclosed or unopened brace
+	ErrUnbalancedBraces = errors.New("unbalanced braces")
+	// ErrAllCaps is reported for a title written in capitals
+	ErrAllCaps = errors.New("all caps")
+)
+
+// titleFields are the fields holding titles
+var titleFields = []string{"title", "booktitle"}
+
+// smallWords stay in lowercase in title case unless they start or end the title
+var smallWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true, "by": true,
+	"for": true, "from": true, "in": true, "into": true, "nor": true, "of": true, "on": true,
+	"or": true, "over": true, "the": true, "to": true, "via": true, "vs": true, "with": true,
+}
+
+// TitleWords are the proper nouns and acronyms keeping their case in titles, by the lowercase
+type TitleWords map[string]string
+
+// LoadTitleWords reads the word list file
+func LoadTitleWords(path string) (TitleWords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("title words: %w", err)
+	}
+	defer f.Close()
+	return ReadTitleWords(f)
+}
+
+// ReadTitleWords reads a word per line, the lines starting with # are comments
+func ReadTitleWords(r io.Reader) (TitleWords, error) {
+	tw := make(TitleWords)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		tw[strings.ToLower(word)] = word
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("title words: %w", err)
+	}
+	return tw, nil
+}
+
+// titleToken is a part of a title
+type titleToken struct {
+	text string
+	word bool // a word outside braces, which the case conversions apply to
+}
+
+// tokenizeTitle splits the title into the words and the rest (i.e., braced groups,
+// LaTeX commands, spaces, and punctuation)
+func tokenizeTitle(title string) []titleToken {
+	var tokens []titleToken
+	runes := []rune(title)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		word := false
+		switch r := runes[i]; {
+		case r == '{':
+			for depth := 1; j < len(runes) && depth > 0; j++ {
+				switch runes[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+		case r == '\\':
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			if j == i+1 && j < len(runes) {
+				j++ // a control symbol such as \&
+			}
+		case isWordRune(r):
+			for j < len(runes) && (isWordRune(runes[j]) ||
+				runes[j] == '\'' && j+1 < len(runes) && unicode.IsLetter(runes[j+1])) {
+				j++
+			}
+			word = true
+		default:
+			for j < len(runes) && runes[j] != '{' && runes[j] != '\\' && !isWordRune(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, titleToken{text: string(runes[i:j]), word: word})
+		i = j
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isAcronym checks if the word has a capital after the first letter (e.g., IEEE, IoT, or 5G)
+func isAcronym(word string) bool {
+	for i, r := range word {
+		if i > 0 && unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllCaps checks if the words outside braces are written in capitals,
+// a few words like `IEEE INFOCOM` are acronyms rather
+func isAllCaps(tokens []titleToken) bool {
+	words := 0
+	for _, token := range tokens {
+		if !token.word || len([]rune(token.text)) < 2 {
+			continue
+		}
+		if strings.IndexFunc(token.text, unicode.IsLower) >= 0 {
+			return false
+		}
+		words++
+	}
+	return words > 2
+}
+
+// FormatTitle brace-protects the acronyms and the words in tw if protect,
+// and converts the other words to the style
+func (tw TitleWords) FormatTitle(title string, protect bool, style string) string {
+	tokens := tokenizeTitle(title)
+	// the acronyms can't be told from the other words in capitals
+	allCaps := isAllCaps(tokens)
+
+	// the last word can be in braces or a command
+	lastWord := -1
+	for i, token := range tokens {
+		if strings.IndexFunc(token.text, isWordRune) >= 0 {
+			lastWord = i
+		}
+	}
+
+	var sb strings.Builder
+	first := true
+	for i, token := range tokens {
+		if !token.word {
+			sb.WriteString(token.text)
+			// a subtitle starts like a title
+			if strings.ContainsAny(token.text, ":?!") {
+				first = true
+			}
+			continue
+		}
+
+		word := token.text
+		listed, ok := tw[strings.ToLower(word)]
+		switch {
+		case ok || (!allCaps && isAcronym(word)):
+			if ok {
+				word = listed
+			}
+			if protect {
+				word = "{" + word + "}"
+			}
+		case style == TitleCaseSentence:
+			word = strings.ToLower(word)
+			if first {
+				word = capitalize(word)
+			}
+		case style == TitleCaseTitle:
+			word = strings.ToLower(word)
+			if first || i == lastWord || !smallWords[word] {
+				word = capitalize(word)
+			}
+		}
+		sb.WriteString(word)
+		first = false
+	}
+	return sb.String()
+}
+
+// capitalize returns the word with the first letter in uppercase
+func capitalize(word string) string {
+	runes := []rune(word)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// CheckTitle returns the problems of the title, unbalanced braces or all caps
+func CheckTitle(title string) []error {
+	var errs []error
+	depth := 0
+	for _, r := range title {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		errs = append(errs, ErrUnbalancedBraces)
+	}
+	if isAllCaps(tokenizeTitle(title)) {
+		errs = append(errs, ErrAllCaps)
+	}
+	return errs
+}
+
+// FormatTitles applies FormatTitle to the title and booktitle of bi,
+// the booktitle is the name of a venue and keeps its case
+func (tw TitleWords) FormatTitles(bi *BibItem, protect bool, style string) {
+	for _, fieldName := range titleFields {
+		value, _ := bi.FieldValueByBibTexName(fieldName)
+		if isPlaceholder(value) {
+			continue
+		}
+		fieldStyle := style
+		if fieldName != "title" {
+			fieldStyle = TitleCaseKeep
+		}
+		_ = bi.SetFieldByBibTexName(fieldName, tw.FormatTitle(value, protect, fieldStyle))
+	}
+}
+
+// CheckTitles returns the problems of the title and booktitle of bi as BuildErrors
+func CheckTitles(bi BibItem) []error {
+	var errs []error
+	for _, fieldName := range titleFields {
+		value, _ := bi.FieldValueByBibTexName(fieldName)
+		for _, err := range CheckTitle(value) {
+			errs = append(errs, &BuildError{CiteName: bi.CiteName, Err: fmt.Errorf("%v: %w: %v", fieldName, err, value)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,64 @@
+package bibfuse
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var formattitletests = []struct {
+	in      string
+	protect bool
+	style   string
+	out     string
+}{
+	{"Deep Learning for IoT Devices", true, TitleCaseKeep, "Deep Learning for {IoT} Devices"},
+	{"Deep Learning for IoT Devices", true, TitleCaseSentence, "Deep learning for {IoT} devices"},
+	{"bayesian inference on the IEEE 802.11 MAC", true, TitleCaseSentence, "{Bayesian} inference on the {IEEE} 802.11 {MAC}"},
+	{"a survey of {LaTeX} tools: the state of the art", false, TitleCaseTitle, "A Survey of {LaTeX} Tools: The State of the Art"},
+	{"5G networks in \\emph{Europe}", true, TitleCaseTitle, "{5G} Networks in \\emph{Europe}"},
+	{"{A Journal Article}", true, TitleCaseSentence, "{A Journal Article}"},
+	{"A STUDY OF THINGS", true, TitleCaseKeep, "A STUDY OF THINGS"},
+	{"Alice's Adventures with Bayesian Methods", false, TitleCaseSentence, "Alice's adventures with Bayesian methods"},
+}
+
+func TestFormatTitle(t *testing.T) {
+	tw, err := ReadTitleWords(strings.NewReader("# proper nouns\nBayesian\n\nAlice\n"))
+	if err != nil {
+		t.Fatalf("ReadTitleWords() err => %v, want nil", err)
+	}
+	for _, tt := range formattitletests {
+		if got := tw.FormatTitle(tt.in, tt.protect, tt.style); got != tt.out {
+			t.Errorf("FormatTitle(%q, %v, %q) => %q, want %q", tt.in, tt.protect, tt.style, got, tt.out)
+		}
+	}
+}
+
+var checktitletests = []struct {
+	in   string
+	errs []error
+}{
+	{"A Fine Title", nil},
+	{"A STUDY OF THINGS", []error{ErrAllCaps}},
+	{"IEEE", nil},
+	{"An {Unclosed Title", []error{ErrUnbalancedBraces}},
+	{"A} B {C", []error{ErrUnbalancedBraces}},
+	{"{A STUDY OF THINGS}", nil},
+}
+
+func TestCheckTitle(t *testing.T) {
+	for _, tt := range checktitletests {
+		if got := CheckTitle(tt.in); !reflect.DeepEqual(got, tt.errs) {
+			t.Errorf("CheckTitle(%q) => %v, want %v", tt.in, got, tt.errs)
+		}
+	}
+
+	bi := NewBibItem()
+	bi.CiteName = "shout"
+	bi.Title = "A STUDY OF THINGS"
+	errs := CheckTitles(bi)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrAllCaps) || !strings.HasPrefix(errs[0].Error(), "[shout] title:") {
+		t.Errorf("CheckTitles() => %v, want [shout] title: all caps", errs)
+	}
+}
@@ -0,0 +1,140 @@
+package bibfuse
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// venueFields are the fields holding the name of a venue
+var venueFields = []string{"journal", "booktitle"}
+
+// Venue is the canonical name and the ISO4 abbreviation of a journal or a conference,
+// with the variants of the name found in the wild
+type Venue struct {
+	Key    string
+	Name   string
+	Abbrev string
+	Exact  []string         // the variants matched ignoring the case, braces, and spaces
+	Regex  []*regexp.Regexp // the variants matched by the patterns
+}
+
+// Venues is the registry of the venues, the first one matching a value is used
+type Venues []*Venue
+
+// LoadVenues reads the venue registry file
+func LoadVenues(path string) (Venues, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("venues: %w", err)
+	}
+	return newVenues(v, path)
+}
+
+// ReadVenues reads a venue registry of the configType (e.g., toml) from r
+func ReadVenues(r io.Reader, configType string) (Venues, error) {
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(r); err != nil {
+		return nil, fmt.Errorf("venues: %w", err)
+	}
+	return newVenues(v, "venues")
+}
+
+// newVenues builds the venues from the tables in v, sorted by the key
+func newVenues(v *viper.Viper, source string) (Venues, error) {
+	keys := make([]string, 0)
+	for key, table := range v.AllSettings() {
+		if _, ok := table.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s: %s: want a venue table", source, key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var vs Venues
+	for _, key := range keys {
+		venue := &Venue{
+			Key:    key,
+			Name:   v.GetString(key + ".name"),
+			Abbrev: v.GetString(key + ".abbrev"),
+			Exact:  v.GetStringSlice(key + ".exact"),
+		}
+		if venue.Name == "" {
+			return nil, fmt.Errorf("%s: %s.name: missing the canonical name", source, key)
+		}
+		for _, pattern := range v.GetStringSlice(key + ".regex") {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.regex: %w", source, key, err)
+			}
+			venue.Regex = append(venue.Regex, re)
+		}
+		vs = append(vs, venue)
+	}
+	return vs, nil
+}
+
+// venueKey returns the value to compare the exact variants with
+func venueKey(value string) string {
+	value = strings.NewReplacer("{", "", "}", "").Replace(value)
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// Match returns the venue of the value, the canonical name and the abbreviation match as well
+func (vs Venues) Match(value string) (*Venue, bool) {
+	if isPlaceholder(value) {
+		return nil, false
+	}
+	key := venueKey(value)
+	for _, venue := range vs {
+		if key == venueKey(venue.Name) || (venue.Abbrev != "" && key == venueKey(venue.Abbrev)) {
+			return venue, true
+		}
+		for _, exact := range venue.Exact {
+			if key == venueKey(exact) {
+				return venue, true
+			}
+		}
+	}
+	for _, venue := range vs {
+		for _, re := range venue.Regex {
+			if re.MatchString(value) {
+				return venue, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Normalize replaces the journal and booktitle of bi with the canonical names,
+// and returns the fields changed
+func (vs Venues) Normalize(bi *BibItem) []string {
+	var changed []string
+	for _, fieldName := range venueFields {
+		value, _ := bi.FieldValueByBibTexName(fieldName)
+		venue, ok := vs.Match(value)
+		if !ok || value == venue.Name {
+			continue
+		}
+		_ = bi.SetFieldByBibTexName(fieldName, venue.Name)
+		changed = append(changed, fieldName)
+	}
+	return changed
+}
+
+// Abbreviate replaces the journal and booktitle of bi with the abbreviations
+// of the venues having one
+func (vs Venues) Abbreviate(bi *BibItem) {
+	for _, fieldName := range venueFields {
+		value, _ := bi.FieldValueByBibTexName(fieldName)
+		if venue, ok := vs.Match(value); ok && venue.Abbrev != "" {
+			_ = bi.SetFieldByBibTexName(fieldName, venue.Abbrev)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package bibfuse
+
+import (
+	"strings"
+	"testing"
+)
+
+const venuesTOML = `
+[infocom]
+name = "IEEE Conference on Computer Communications"
+abbrev = "Proc. IEEE INFOCOM"
+regex = ['(?i)\binfocom\b']
+
+[ton]
+name = "IEEE/ACM Transactions on Networking"
+abbrev = "IEEE/ACM Trans. Netw."
+exact = ["ToN", "IEEE ToN"]
+`
+
+var venuetests = []struct {
+	in   string
+	want string
+}{
+	{"Proc. of IEEE INFOCOM", "infocom"},
+	{"IEEE INFOCOM 2020 - IEEE Conference on Computer Communications", "infocom"},
+	{"{IEEE} Conference on  Computer Communications", "infocom"},
+	{"ieee ton", "ton"},
+	{"IEEE/ACM Trans. Netw.", "ton"},
+	{"Transactions on Networking", ""},
+	{"(TODO)", ""},
+}
+
+func TestVenuesMatch(t *testing.T) {
+	venues, err := ReadVenues(strings.NewReader(venuesTOML), "toml")
+	if err != nil {
+		t.Fatalf("ReadVenues() err => %v, want nil", err)
+	}
+	for _, tt := range venuetests {
+		venue, ok := venues.Match(tt.in)
+		got := ""
+		if ok {
+			got = venue.Key
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) => %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVenuesNormalize(t *testing.T) {
+	venues, err := ReadVenues(strings.NewReader(venuesTOML), "toml")
+	if err != nil {
+		t.Fatalf("ReadVenues() err => %v, want nil", err)
+	}
+	bi := NewBibItem()
+	bi.Journal = "ToN"
+	bi.Booktitle = "Proc. IEEE INFOCOM"
+	if changed := venues.Normalize(&bi); len(changed) != 2 {
+		t.Errorf("Normalize() => %v, want [journal booktitle]", changed)
+	}
+	if bi.Journal != "IEEE/ACM Transactions on Networking" || bi.Booktitle != "IEEE Conference on Computer Communications" {
+		t.Errorf("Normalize() => %v, %v, want the canonical names", bi.Journal, bi.Booktitle)
+	}
+	venues.Abbreviate(&bi)
+	if bi.Journal != "IEEE/ACM Trans. Netw." || bi.Booktitle != "Proc. IEEE INFOCOM" {
+		t.Errorf("Abbreviate() => %v, %v, want the abbreviations", bi.Journal, bi.Booktitle)
+	}
+
+	if _, err := ReadVenues(strings.NewReader("[x]\nabbrev = \"X\"\n"), "toml"); err == nil {
+		t.Errorf("ReadVenues() err => nil, want missing the canonical name")
+	}
+}
@@ -0,0 +1,187 @@
+package bibfuse
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nickng/bibtex"
+)
+
+// the delimiters of the values
+const (
+	DelimiterQuotes = "quotes"
+	DelimiterBraces = "braces"
+)
+
+// the cases of the entry types and the field names
+const (
+	KeyCaseLower = "lower"
+	KeyCaseUpper = "upper"
+)
+
+// WriteOptions are the styles of the bibtex written by Writer
+type WriteOptions struct {
+	Delimiter     string // DelimiterQuotes or DelimiterBraces, the values with quotes or braces are braced anyway
+	Indent        string // the indentation of the fields, e.g., four spaces or a tab
+	Align         bool   // align the = of the fields in an entry
+	TrailingComma bool   // put a comma after the last field
+	KeyCase       string // KeyCaseLower or KeyCaseUpper
+	Wrap          int    // the columns to wrap the long values at the spaces in, or 0 not to wrap
+}
+
+// DefaultWriteOptions returns the options writing as bibtex.PrettyString does
+func DefaultWriteOptions() WriteOptions {
+	return WriteOptions{
+		Delimiter:     DelimiterQuotes,
+		Indent:        "    ",
+		Align:         true,
+		TrailingComma: true,
+		KeyCase:       KeyCaseLower,
+	}
+}
+
+// Validate checks the delimiter and the key case
+func (opts WriteOptions) Validate() error {
+	switch opts.Delimiter {
+	case DelimiterQuotes, DelimiterBraces:
+	default:
+		return fmt.Errorf("delimiter %q: want %s or %s", opts.Delimiter, DelimiterQuotes, DelimiterBraces)
+	}
+	switch opts.KeyCase {
+	case KeyCaseLower, KeyCaseUpper:
+	default:
+		return fmt.Errorf("key case %q: want %s or %s", opts.KeyCase, KeyCaseLower, KeyCaseUpper)
+	}
+	if opts.Wrap < 0 {
+		return fmt.Errorf("wrap %d: want the columns or 0", opts.Wrap)
+	}
+	return nil
+}
+
+// Writer writes the entries in the style of the options
+type Writer struct {
+	w       io.Writer
+	opts    WriteOptions
+	written bool
+}
+
+// NewWriter returns a Writer writing to w
+func NewWriter(w io.Writer, opts WriteOptions) *Writer {
+	return &Writer{w: w, opts: opts}
+}
+
+// WriteBibTex writes the entries of bib
+func (w *Writer) WriteBibTex(bib *bibtex.BibTex) error {
+	for _, entry := range bib.Entries {
+		if err := w.WriteEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEntry writes the entry after a blank line unless the first, the fields are in the order of
+// bibtex.PrettyString (i.e., title, author, url, and the rest in the alphabetical order)
+func (w *Writer) WriteEntry(entry *bibtex.BibEntry) error {
+	var sb strings.Builder
+	if w.written {
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "@%s{%s,\n", w.keyCase(entry.Type), entry.CiteName)
+
+	keys := make([]string, 0, len(entry.Fields))
+	width := 0
+	for key := range entry.Fields {
+		keys = append(keys, key)
+		if n := utf8.RuneCountInString(key); n > width {
+			width = n
+		}
+	}
+	priority := map[string]int{"title": -3, "author": -2, "url": -1}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := priority[keys[i]], priority[keys[j]]
+		return pi < pj || (pi == pj && keys[i] < keys[j])
+	})
+
+	for i, key := range keys {
+		name := w.keyCase(key)
+		if w.opts.Align {
+			name += strings.Repeat(" ", width-utf8.RuneCountInString(key))
+		}
+		prefix := w.opts.Indent + name + " = "
+		sb.WriteString(prefix)
+		sb.WriteString(w.wrap(w.formatValue(entry.Fields[key]), prefix))
+		if i < len(keys)-1 || w.opts.TrailingComma {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("}\n")
+
+	w.written = true
+	_, err := io.WriteString(w.w, sb.String())
+	return err
+}
+
+func (w *Writer) keyCase(key string) string {
+	if w.opts.KeyCase == KeyCaseUpper {
+		return strings.ToUpper(key)
+	}
+	return strings.ToLower(key)
+}
+
+// formatValue returns the value delimited, the numbers and the string variables are bare,
+// and the parts of a concatenation are joined with #
+func (w *Writer) formatValue(value bibtex.BibString) string {
+	switch v := value.(type) {
+	case *bibtex.BibVar:
+		return v.Key
+	case *bibtex.BibComposite:
+		parts := make([]string, len(*v))
+		for i, part := range *v {
+			parts[i] = w.formatValue(part)
+		}
+		return strings.Join(parts, " # ")
+	}
+	s := value.String()
+	if _, err := strconv.Atoi(s); err == nil {
+		return s
+	}
+	if w.opts.Delimiter == DelimiterBraces || strings.ContainsAny(s, "\"{}") {
+		return "{" + s + "}"
+	}
+	return "\"" + s + "\""
+}
+
+// wrap breaks the value at the spaces to fit the lines in the columns after the prefix,
+// the lines continued are indented to the value
+func (w *Writer) wrap(value, prefix string) string {
+	column := utf8.RuneCountInString(strings.ReplaceAll(prefix, "\t", "        "))
+	if w.opts.Wrap == 0 || column+utf8.RuneCountInString(value) < w.opts.Wrap {
+		return value
+	}
+	indent := "\n" + strings.Repeat(" ", column+1)
+
+	var sb strings.Builder
+	words := strings.Split(value, " ")
+	width := column
+	for i, word := range words {
+		n := utf8.RuneCountInString(word)
+		switch {
+		case i == 0:
+		case width+1+n >= w.opts.Wrap && width > column:
+			sb.WriteString(indent)
+			width = column + 1
+		default:
+			sb.WriteString(" ")
+			width++
+		}
+		sb.WriteString(word)
+		width += n
+	}
+	return sb.String()
+}
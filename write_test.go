@@ -0,0 +1,77 @@
+package bibfuse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickng/bibtex"
+)
+
+func TestWriterDefault(t *testing.T) {
+	bib := bibtex.NewBibTex()
+	for _, tt := range bibitemtests {
+		bib.AddEntry(tt.in.ToBibEntry())
+		bib.AddEntry(tt.in.ToBibEntry())
+	}
+	var sb strings.Builder
+	if err := NewWriter(&sb, DefaultWriteOptions()).WriteBibTex(bib); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sb.String(), bib.PrettyString(); got != want {
+		t.Errorf("WriteBibTex() => \n%v, want \n%v", got, want)
+	}
+}
+
+var writertests = []struct {
+	opts WriteOptions
+	out  string
+}{
+	{
+		WriteOptions{Delimiter: DelimiterBraces, Indent: "\t", KeyCase: KeyCaseLower},
+		"@article{key,\n\ttitle = {A Title on {RFID}},\n\tauthor = {Mizutani, Iori},\n\tjournal = ieee,\n\tyear = 2021\n}\n",
+	},
+	{
+		WriteOptions{Delimiter: DelimiterQuotes, Indent: "  ", Align: true, TrailingComma: true, KeyCase: KeyCaseUpper},
+		"@ARTICLE{key,\n  TITLE   = {A Title on {RFID}},\n  AUTHOR  = \"Mizutani, Iori\",\n  JOURNAL = ieee,\n  YEAR    = 2021,\n}\n",
+	},
+	{
+		WriteOptions{Delimiter: DelimiterBraces, Indent: "  ", KeyCase: KeyCaseLower, Wrap: 24},
+		"@article{key,\n  title = {A Title on\n           {RFID}},\n  author = {Mizutani,\n            Iori},\n  journal = ieee,\n  year = 2021\n}\n",
+	},
+}
+
+func TestWriter(t *testing.T) {
+	entry := bibtex.NewBibEntry("article", "key")
+	entry.AddField("title", bibtex.NewBibConst("A Title on {RFID}"))
+	entry.AddField("author", bibtex.NewBibConst("Mizutani, Iori"))
+	entry.AddField("journal", &bibtex.BibVar{Key: "ieee", Value: bibtex.NewBibConst("IEEE")})
+	entry.AddField("year", bibtex.NewBibConst("2021"))
+	for _, tt := range writertests {
+		if err := tt.opts.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		if err := NewWriter(&sb, tt.opts).WriteEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+		if got := sb.String(); got != tt.out {
+			t.Errorf("WriteEntry(%+v) => \n%v, want \n%v", tt.opts, got, tt.out)
+		}
+	}
+	if err := (WriteOptions{Delimiter: "single", KeyCase: KeyCaseLower}).Validate(); err == nil {
+		t.Errorf("Validate() => nil, want an error for the delimiter")
+	}
+}
+
+func TestWriterComposite(t *testing.T) {
+	entry := bibtex.NewBibEntry("misc", "key")
+	note := bibtex.BibComposite{bibtex.NewBibConst("Vol. "), &bibtex.BibVar{Key: "acm", Value: bibtex.NewBibConst("ACM")}}
+	entry.AddField("note", &note)
+	var sb strings.Builder
+	if err := NewWriter(&sb, DefaultWriteOptions()).WriteEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sb.String(), "@misc{key,\n    note = \"Vol. \" # acm,\n}\n"; got != want {
+		t.Errorf("WriteEntry() => %q, want %q", got, want)
+	}
+}